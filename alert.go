@@ -0,0 +1,95 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// An AlertConfig stores where to send alerts
+type AlertConfig struct {
+	WebhookURL string        // URL to POST alerts to (optional)
+	MqttTopic  string        // MQTT topic to publish alerts to (optional)
+	Timeout    time.Duration // how much time to wait for the webhook to respond
+}
+
+// An Alert is sent to the configured hooks when something noteworthy happens
+type Alert struct {
+	Type      string      `json:"type"`
+	Timestamp UnixEpoch   `json:"ts"`
+	Data      interface{} `json:"data"`
+}
+
+// sendAlert posts the alert to the webhook and publishes it to the MQTT
+// broker, if configured. Delivery happens in the background so that the
+// processing of TIC messages is not delayed.
+func (processor *Processor) sendAlert(alert Alert) {
+	config := processor.Config.Alert
+	if config.WebhookURL == "" && config.MqttTopic == "" {
+		return
+	}
+
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		processor.Config.Logger.Println(err)
+		return
+	}
+
+	if config.WebhookURL != "" {
+		go func() {
+			if err := postWebhook(config, payload); err != nil {
+				processor.Config.Logger.Println(err)
+			}
+		}()
+	}
+
+	if config.MqttTopic != "" && processor.client != nil {
+		token := processor.client.Publish(config.MqttTopic, MQTT_QOS_1, false, payload)
+		go func() {
+			if !token.WaitTimeout(processor.Config.Mqtt.Timeout) {
+				processor.Config.Logger.Println("mqtt: timeout waiting for alert publication")
+			} else if token.Error() != nil {
+				processor.Config.Logger.Println(token.Error())
+			}
+		}()
+	}
+}
+
+// postWebhook sends the JSON payload to the configured webhook
+func postWebhook(config AlertConfig, payload []byte) error {
+	client := http.Client{Timeout: config.Timeout}
+	resp, err := client.Post(config.WebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -22,10 +22,9 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"database/sql"
 	"os"
+	"strings"
 
-	_ "github.com/jackc/pgx/v4/stdlib"
 	ticTsdb "github.com/nmasse-itix/tic-tsdb"
 	"github.com/pressly/goose/v3"
 	"github.com/spf13/cobra"
@@ -50,47 +49,62 @@ create NAME [sql|go] Creates new migration file with the current timestamp
 fix                  Apply sequential ordering to migrations
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		ok := true
-		if viper.GetString("sql.database") == "" {
-			logger.Println("No database name defined in configuration")
-			ok = false
-		}
-		if viper.GetString("sql.hostname") == "" {
-			logger.Println("No database server defined in configuration")
-			ok = false
-		}
+		ok := checkDatabaseConfig()
 		if len(args) < 1 {
 			logger.Println("Please specify goose command!")
 			ok = false
 		}
+		policies, err := getPolicyConfig()
+		if err != nil {
+			logger.Println(err)
+			ok = false
+		}
 		if !ok {
 			logger.Println()
 			cmd.Help()
 			os.Exit(1)
 		}
 
-		dbUrl := getDatabaseUrl()
-		logger.Println("Connecting to PostgreSQL server...")
-		db, err := sql.Open("pgx", dbUrl)
+		logger.Println("Connecting to the database...")
+		db, migrator, err := ticTsdb.OpenDb(ticTsdb.SqlConfig{
+			Driver:         viper.GetString("sql.driver"),
+			Url:            getDatabaseUrl(),
+			Schema:         viper.GetString("sql.schema"),
+			LegacyTimezone: viper.GetString("sql.legacyTimezone"),
+			Timezone:       viper.GetString("sql.timezone"),
+		})
 		if err != nil {
 			logger.Println(err)
 			os.Exit(1)
 		}
 		defer db.Close()
 
-		goose.SetBaseFS(ticTsdb.SqlMigrationFS)
-
-		if err := goose.SetDialect("postgres"); err != nil {
+		dir, err := migrator.SetupGoose()
+		if err != nil {
 			logger.Println(err)
 			os.Exit(1)
 		}
 
 		gooseCmd := args[0]
 		gooseOpts := args[1:]
-		if err := goose.Run(gooseCmd, db, "schemas", gooseOpts...); err != nil {
+		if strings.HasPrefix(gooseCmd, "up") {
+			if err := migrator.CreateDbSchema(); err != nil {
+				logger.Println(err)
+				os.Exit(1)
+			}
+		}
+		if err := goose.Run(gooseCmd, db, dir, gooseOpts...); err != nil {
 			logger.Println(err)
 			os.Exit(1)
 		}
+
+		if strings.HasPrefix(gooseCmd, "up") {
+			logger.Println("Applying retention and compression policies...")
+			if err := migrator.ApplyPolicies(policies); err != nil {
+				logger.Println(err)
+				os.Exit(1)
+			}
+		}
 	},
 }
 
@@ -0,0 +1,86 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	ticTsdb "github.com/nmasse-itix/tic-tsdb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// policiesCmd represents the policies command
+var policiesCmd = &cobra.Command{
+	Use:   "policies",
+	Short: "Show the retention and compression policies",
+	Long: `Show the retention and compression policies currently in place on
+each hypertable. Policies are configured with the retention.raw,
+retention.energy and compression.after keys and applied by the process
+and db migrate commands.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !checkDatabaseConfig() {
+			logger.Println()
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		db, migrator, err := ticTsdb.OpenDb(ticTsdb.SqlConfig{
+			Driver: viper.GetString("sql.driver"),
+			Url:    getDatabaseUrl(),
+			Schema: viper.GetString("sql.schema"),
+		})
+		if err != nil {
+			logger.Println(err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		policies, err := migrator.GetPolicies()
+		if err != nil {
+			logger.Println(err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HYPERTABLE\tCOMPRESSION\tCOMPRESS AFTER\tDROP AFTER")
+		for _, policy := range policies {
+			fmt.Fprintf(w, "%s\t%t\t%s\t%s\n", policy.Hypertable, policy.CompressionEnabled, nullString(policy.CompressAfter), nullString(policy.DropAfter))
+		}
+		w.Flush()
+	},
+}
+
+// nullString returns the value of a nullable string or "-" when it is null
+func nullString(s sql.NullString) string {
+	if !s.Valid {
+		return "-"
+	}
+	return s.String
+}
+
+func init() {
+	dbCmd.AddCommand(policiesCmd)
+}
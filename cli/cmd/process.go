@@ -22,7 +22,11 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	ticTsdb "github.com/nmasse-itix/tic-tsdb"
 	"github.com/spf13/cobra"
@@ -35,17 +39,45 @@ var processCmd = &cobra.Command{
 	Short: "Saves MQTT events to TimescaleDB",
 	Long:  `TODO`,
 	Run: func(cmd *cobra.Command, args []string) {
-		ok := true
-		if viper.GetString("sql.database") == "" {
-			logger.Println("No database name defined in configuration")
+		ok := checkDatabaseConfig()
+		if viper.GetString("mqtt.broker") == "" {
+			logger.Println("No MQTT broker defined in configuration")
 			ok = false
 		}
-		if viper.GetString("sql.hostname") == "" {
-			logger.Println("No database server defined in configuration")
+		switch viper.GetString("sql.migrate") {
+		case ticTsdb.MIGRATE_AUTO, ticTsdb.MIGRATE_CHECK, ticTsdb.MIGRATE_SKIP:
+		default:
+			logger.Println("Migration mode must be one of auto, check or skip")
 			ok = false
 		}
-		if viper.GetString("mqtt.broker") == "" {
-			logger.Println("No MQTT broker defined in configuration")
+		policies, err := getPolicyConfig()
+		if err != nil {
+			logger.Println(err)
+			ok = false
+		}
+		dedup, err := getLabelDurations("dedup")
+		if err != nil {
+			logger.Println(err)
+			ok = false
+		}
+		windows, err := getLabelDurations("downsample.windows")
+		if err != nil {
+			logger.Println(err)
+			ok = false
+		}
+		lateData, err := getLateDataConfig()
+		if err != nil {
+			logger.Println(err)
+			ok = false
+		}
+		bounds, err := getBounds()
+		if err != nil {
+			logger.Println(err)
+			ok = false
+		}
+		sinks, err := getSinks()
+		if err != nil {
+			logger.Println(err)
 			ok = false
 		}
 		if !ok {
@@ -57,7 +89,17 @@ var processCmd = &cobra.Command{
 		logger.Println("Dispatching...")
 		config := ticTsdb.ProcessorConfig{
 			Sql: ticTsdb.SqlConfig{
-				Url: getDatabaseUrl(),
+				Driver:          viper.GetString("sql.driver"),
+				Url:             getDatabaseUrl(),
+				MaxOpenConns:    viper.GetInt("sql.maxOpenConns"),
+				MaxIdleConns:    viper.GetInt("sql.maxIdleConns"),
+				ConnMaxLifetime: viper.GetDuration("sql.connMaxLifetime"),
+				ConnMaxIdleTime: viper.GetDuration("sql.connMaxIdleTime"),
+				Migrate:         viper.GetString("sql.migrate"),
+				Schema:          viper.GetString("sql.schema"),
+				LegacyTimezone:  viper.GetString("sql.legacyTimezone"),
+				Timezone:        viper.GetString("sql.timezone"),
+				PartitionsAhead: viper.GetInt("sql.partitionsAhead"),
 			},
 			Mqtt: ticTsdb.MqttConfig{
 				BrokerURL:   viper.GetString("mqtt.broker"),
@@ -67,10 +109,51 @@ var processCmd = &cobra.Command{
 				Timeout:     viper.GetDuration("mqtt.timeout"),
 				GracePeriod: viper.GetDuration("mqtt.gracePeriod"),
 			},
+			Alert: ticTsdb.AlertConfig{
+				WebhookURL: viper.GetString("alert.webhook"),
+				MqttTopic:  viper.GetString("alert.mqttTopic"),
+				Timeout:    viper.GetDuration("alert.timeout"),
+			},
+			Overload: ticTsdb.OverloadConfig{
+				HoldTime: viper.GetDuration("overload.holdTime"),
+			},
+			Voltage: ticTsdb.VoltageConfig{
+				Min:      viper.GetInt64("voltage.min"),
+				Max:      viper.GetInt64("voltage.max"),
+				Duration: viper.GetDuration("voltage.duration"),
+			},
+			Retry: ticTsdb.RetryConfig{
+				InitialInterval: viper.GetDuration("retry.initialInterval"),
+				MaxInterval:     viper.GetDuration("retry.maxInterval"),
+				MaxAttempts:     viper.GetInt("retry.maxAttempts"),
+				WriteAttempts:   viper.GetInt("retry.writeAttempts"),
+			},
+			LateData: lateData,
+			Validation: ticTsdb.ValidationConfig{
+				Bounds:               bounds,
+				EnergyRateMargin:     viper.GetFloat64("validation.energyRateMargin"),
+				ConsistencyTolerance: viper.GetFloat64("validation.consistencyTolerance"),
+				NominalVoltage:       viper.GetInt64("validation.nominalVoltage"),
+			},
+			Reset: ticTsdb.ResetConfig{
+				Confirmations: viper.GetInt("reset.confirmations"),
+			},
+			Policies: policies,
+			Dedup:    dedup,
+			Downsample: ticTsdb.DownsampleConfig{
+				Windows: windows,
+				KeepRaw: viper.GetBool("downsample.keepRaw"),
+			},
+			Sink: ticTsdb.SinkConfig{
+				BatchSize:     viper.GetInt("sink.batchSize"),
+				FlushInterval: viper.GetDuration("sink.flushInterval"),
+				QueueLength:   viper.GetInt("sink.queueLength"),
+			},
+			Sinks:  sinks,
 			Logger: logger,
 		}
 		processor := ticTsdb.NewProcessor(config)
-		err := processor.Process()
+		err = processor.Process()
 		if err != nil {
 			logger.Println(err)
 			os.Exit(1)
@@ -78,6 +161,148 @@ var processCmd = &cobra.Command{
 	},
 }
 
+// getLabelDurations reads a map of TIC labels to durations from the
+// configuration, such as the heartbeat interval of the labels configured for
+// change-only storage.
+func getLabelDurations(key string) (map[string]time.Duration, error) {
+	durations := make(map[string]time.Duration)
+	for label, value := range viper.GetStringMapString(key) {
+		d, err := parseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		// viper lowercases keys while TIC labels are uppercase
+		durations[strings.ToUpper(label)] = d
+	}
+	return durations, nil
+}
+
+// getLateDataConfig reads the conflict policies and the clock-skew guard from
+// the configuration. A conflict policy is either the name of the policy or
+// "reject-if-older-than" followed by a duration.
+func getLateDataConfig() (ticTsdb.LateDataConfig, error) {
+	var config ticTsdb.LateDataConfig
+	var err error
+	if config.MaxFuture, err = parseDuration(viper.GetString("lateData.maxFuture")); err != nil {
+		return config, err
+	}
+
+	config.Conflicts = make(map[string]ticTsdb.ConflictPolicy)
+	for table, value := range viper.GetStringMapString("lateData.conflicts") {
+		fields := strings.Fields(value)
+		if len(fields) == 0 || len(fields) > 2 {
+			return config, fmt.Errorf("invalid conflict policy %q for %s", value, table)
+		}
+
+		policy := ticTsdb.ConflictPolicy{Policy: fields[0]}
+		if len(fields) == 2 {
+			if policy.MaxAge, err = parseDuration(fields[1]); err != nil {
+				return config, err
+			}
+		}
+		if policy.Policy == ticTsdb.CONFLICT_REJECT_OLD && policy.MaxAge == 0 {
+			return config, fmt.Errorf("conflict policy %s requires a duration for %s", policy.Policy, table)
+		}
+		config.Conflicts[table] = policy
+	}
+
+	return config, nil
+}
+
+// getBounds reads the acceptable range of each label from the configuration,
+// expressed as "min..max".
+func getBounds() (map[string]ticTsdb.Bounds, error) {
+	bounds := make(map[string]ticTsdb.Bounds)
+	for label, value := range viper.GetStringMapString("validation.bounds") {
+		parts := strings.SplitN(value, "..", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid bounds %q for %s", value, label)
+		}
+
+		var b ticTsdb.Bounds
+		var err error
+		if b.Min, err = strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid bounds %q for %s", value, label)
+		}
+		if b.Max, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid bounds %q for %s", value, label)
+		}
+		// viper lowercases keys while TIC labels are uppercase
+		bounds[strings.ToUpper(label)] = b
+	}
+	return bounds, nil
+}
+
+// getSinks creates the storage backends enabled in the configuration, in
+// addition to the database.
+func getSinks() ([]ticTsdb.NamedSink, error) {
+	var sinks []ticTsdb.NamedSink
+
+	if viper.GetString("influxdb.url") != "" || viper.GetString("influxdb.file") != "" {
+		sink, err := ticTsdb.NewInfluxSink(ticTsdb.InfluxConfig{
+			Url:     viper.GetString("influxdb.url"),
+			Org:     viper.GetString("influxdb.org"),
+			Bucket:  viper.GetString("influxdb.bucket"),
+			Token:   viper.GetString("influxdb.token"),
+			File:    viper.GetString("influxdb.file"),
+			Meter:   viper.GetString("meter"),
+			Timeout: viper.GetDuration("influxdb.timeout"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, ticTsdb.NamedSink{Name: "influxdb", Sink: sink})
+	}
+
+	if viper.GetString("prometheus.url") != "" {
+		sink, err := ticTsdb.NewPrometheusSink(ticTsdb.PrometheusConfig{
+			Url:         viper.GetString("prometheus.url"),
+			Username:    viper.GetString("prometheus.username"),
+			Password:    viper.GetString("prometheus.password"),
+			BearerToken: viper.GetString("prometheus.bearerToken"),
+			Meter:       viper.GetString("meter"),
+			Timeout:     viper.GetDuration("prometheus.timeout"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, ticTsdb.NamedSink{Name: "prometheus", Sink: sink})
+	}
+
+	if viper.GetBool("publish.enabled") {
+		// the readings are republished to the broker the TIC messages come
+		// from, unless another one is configured
+		broker := viper.GetString("publish.broker")
+		username, password := viper.GetString("publish.username"), viper.GetString("publish.password")
+		if broker == "" {
+			broker = viper.GetString("mqtt.broker")
+			username, password = viper.GetString("mqtt.username"), viper.GetString("mqtt.password")
+		}
+
+		sink, err := ticTsdb.NewMqttSink(ticTsdb.PublishConfig{
+			Mqtt: ticTsdb.MqttConfig{
+				BrokerURL:   broker,
+				Username:    username,
+				Password:    password,
+				ClientID:    viper.GetString("mqtt.clientId") + "-publisher",
+				Timeout:     viper.GetDuration("mqtt.timeout"),
+				GracePeriod: viper.GetDuration("mqtt.gracePeriod"),
+			},
+			Prefix: viper.GetString("publish.prefix"),
+			Meter:  viper.GetString("meter"),
+			Retain: viper.GetBool("publish.retain"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, ticTsdb.NamedSink{Name: "publish", Sink: sink})
+	}
+
+	return sinks, nil
+}
+
 func init() {
 	rootCmd.AddCommand(processCmd)
+	processCmd.Flags().String("migrate", ticTsdb.MIGRATE_AUTO, "schema migration mode at startup (auto, check or skip)")
+	viper.BindPFlag("sql.migrate", processCmd.Flags().Lookup("migrate"))
 }
@@ -24,9 +24,13 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
 	"time"
 
+	ticTsdb "github.com/nmasse-itix/tic-tsdb"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -34,8 +38,112 @@ import (
 var cfgFile string
 var logger *log.Logger
 
+// getDatabaseUrl returns the sql.path configuration key for SQLite, the
+// sql.url configuration key when set or builds the database URL from the
+// individual sql.* configuration keys otherwise.
 func getDatabaseUrl() string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable", viper.GetString("sql.username"), viper.GetString("sql.password"), viper.GetString("sql.hostname"), viper.GetInt("sql.port"), viper.GetString("sql.database"))
+	if viper.GetString("sql.driver") == ticTsdb.DRIVER_SQLITE {
+		return viper.GetString("sql.path")
+	}
+
+	if dbUrl := viper.GetString("sql.url"); dbUrl != "" {
+		return dbUrl
+	}
+
+	params := url.Values{}
+	params.Set("sslmode", viper.GetString("sql.sslmode"))
+	if rootCert := viper.GetString("sql.sslrootcert"); rootCert != "" {
+		params.Set("sslrootcert", rootCert)
+	}
+	if cert := viper.GetString("sql.sslcert"); cert != "" {
+		params.Set("sslcert", cert)
+	}
+	if key := viper.GetString("sql.sslkey"); key != "" {
+		params.Set("sslkey", key)
+	}
+	if appName := viper.GetString("sql.applicationName"); appName != "" {
+		params.Set("application_name", appName)
+	}
+
+	dbUrl := url.URL{
+		Scheme:   "postgres",
+		Host:     net.JoinHostPort(viper.GetString("sql.hostname"), strconv.Itoa(viper.GetInt("sql.port"))),
+		Path:     "/" + viper.GetString("sql.database"),
+		RawQuery: params.Encode(),
+	}
+	if username := viper.GetString("sql.username"); username != "" {
+		dbUrl.User = url.UserPassword(username, viper.GetString("sql.password"))
+	}
+
+	return dbUrl.String()
+}
+
+// parseDuration parses a duration that may be expressed in days ("90d") or
+// weeks ("2w") in addition to the units supported by time.ParseDuration.
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if unit, ok := units[value[len(value)-1]]; ok {
+		n, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// getPolicyConfig reads the retention and compression policies from the
+// configuration.
+func getPolicyConfig() (ticTsdb.PolicyConfig, error) {
+	var config ticTsdb.PolicyConfig
+	var err error
+	if config.RawRetention, err = parseDuration(viper.GetString("retention.raw")); err != nil {
+		return config, err
+	}
+	if config.EnergyRetention, err = parseDuration(viper.GetString("retention.energy")); err != nil {
+		return config, err
+	}
+	if config.CompressAfter, err = parseDuration(viper.GetString("compression.after")); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// checkDatabaseConfig logs the missing database configuration keys and
+// returns false if the configuration is incomplete.
+func checkDatabaseConfig() bool {
+	switch viper.GetString("sql.driver") {
+	case ticTsdb.DRIVER_POSTGRES:
+	case ticTsdb.DRIVER_SQLITE:
+		if viper.GetString("sql.path") == "" {
+			logger.Println("No database file defined in configuration")
+			return false
+		}
+		return true
+	default:
+		logger.Println("Database driver must be one of postgres or sqlite")
+		return false
+	}
+
+	if viper.GetString("sql.url") != "" {
+		return true
+	}
+
+	ok := true
+	if viper.GetString("sql.database") == "" {
+		logger.Println("No database name defined in configuration")
+		ok = false
+	}
+	if viper.GetString("sql.hostname") == "" {
+		logger.Println("No database server defined in configuration")
+		ok = false
+	}
+	return ok
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -59,10 +167,38 @@ func init() {
 	logger = log.New(os.Stderr, "", 0)
 
 	// Set default configuration
+	viper.SetDefault("sql.driver", ticTsdb.DRIVER_POSTGRES)
 	viper.SetDefault("sql.port", 5432)
+	viper.SetDefault("sql.sslmode", "disable")
+	viper.SetDefault("sql.applicationName", "tic-tsdb")
+	viper.SetDefault("sql.maxOpenConns", 4)
+	viper.SetDefault("sql.maxIdleConns", 2)
+	viper.SetDefault("sql.connMaxLifetime", 30*time.Minute)
+	viper.SetDefault("sql.connMaxIdleTime", 5*time.Minute)
+	viper.SetDefault("sql.partitionsAhead", 3)
 	viper.SetDefault("mqtt.clientId", "tic-tsdb")
 	viper.SetDefault("mqtt.timeout", 30*time.Second)
 	viper.SetDefault("mqtt.gracePeriod", 5*time.Second)
+	viper.SetDefault("retry.initialInterval", 1*time.Second)
+	viper.SetDefault("retry.maxInterval", 1*time.Minute)
+	viper.SetDefault("retry.maxAttempts", 0)
+	viper.SetDefault("retry.writeAttempts", 3)
+	viper.SetDefault("downsample.keepRaw", true)
+	viper.SetDefault("sink.batchSize", 100)
+	viper.SetDefault("sink.flushInterval", 1*time.Second)
+	viper.SetDefault("sink.queueLength", 1000)
+	viper.SetDefault("meter", "main")
+	viper.SetDefault("influxdb.timeout", 10*time.Second)
+	viper.SetDefault("prometheus.timeout", 10*time.Second)
+	viper.SetDefault("publish.prefix", "tic")
+	viper.SetDefault("publish.retain", true)
+	viper.SetDefault("validation.nominalVoltage", 230)
+	viper.SetDefault("alert.timeout", 10*time.Second)
+	viper.SetDefault("overload.holdTime", 30*time.Second)
+	viper.SetDefault("reset.confirmations", 3)
+	viper.SetDefault("voltage.min", 207)
+	viper.SetDefault("voltage.max", 253)
+	viper.SetDefault("voltage.duration", 10*time.Second)
 
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $PWD/tic-tsdb.yaml)")
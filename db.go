@@ -0,0 +1,146 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Those flags define the database backend
+const (
+	DRIVER_POSTGRES = "postgres" // PostgreSQL with the TimescaleDB extension (default)
+	DRIVER_SQLITE   = "sqlite"   // embedded SQLite database, the URL being the path to the file
+)
+
+// DEFAULT_TIMEZONE is the timezone of the buckets of the aggregates when none
+// is configured. Linky meters are installed in metropolitan France.
+const DEFAULT_TIMEZONE = "Europe/Paris"
+
+// OpenDb opens a connection pool to the database described by the
+// configuration, along with the migrator of its schema. When a schema is
+// configured, it is put first in the search_path of every connection,
+// followed by public where the TimescaleDB functions live, and the migrator
+// targets it. The legacy timezone and the timezone of the buckets are passed
+// to the migrations as the tic.legacy_timezone and tic.timezone settings.
+// With the SQLite driver, the URL is the path to the database file.
+func OpenDb(config SqlConfig) (*sql.DB, *Migrator, error) {
+	switch config.Driver {
+	case DRIVER_POSTGRES, "":
+	case DRIVER_SQLITE:
+		db, err := openSqlite(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, NewMigrator(db, DRIVER_SQLITE, ""), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown database driver %q", config.Driver)
+	}
+
+	connConfig, err := pgx.ParseConfig(config.Url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config.Schema != "" {
+		connConfig.RuntimeParams["search_path"] = pgx.Identifier{config.Schema}.Sanitize() + ", public"
+	}
+
+	if config.LegacyTimezone != "" {
+		connConfig.RuntimeParams["tic.legacy_timezone"] = config.LegacyTimezone
+	}
+	connConfig.RuntimeParams["tic.timezone"] = DEFAULT_TIMEZONE
+	if config.Timezone != "" {
+		connConfig.RuntimeParams["tic.timezone"] = config.Timezone
+	}
+
+	db := stdlib.OpenDB(*connConfig)
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	if config.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	return db, NewMigrator(db, DRIVER_POSTGRES, config.Schema), nil
+}
+
+// HasTimescaleDb returns true if the TimescaleDB extension is installed in
+// the database. Without it, the tables are partitioned natively instead of
+// being hypertables.
+func (m *Migrator) HasTimescaleDb() (bool, error) {
+	if m.driver == DRIVER_SQLITE {
+		return false, nil
+	}
+
+	var installed bool
+	err := m.db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(&installed)
+	return installed, err
+}
+
+// openSqlite opens the SQLite database file described by the configuration.
+// SQLite handles a single writer at a time, so the pool is limited to one
+// connection and waits for locks instead of failing immediately.
+func openSqlite(config SqlConfig) (*sql.DB, error) {
+	if config.Schema != "" {
+		return nil, fmt.Errorf("schemas are not supported by the %s driver", DRIVER_SQLITE)
+	}
+	if config.Url == "" {
+		return nil, fmt.Errorf("no database file defined for the %s driver", DRIVER_SQLITE)
+	}
+	if config.Timezone != "" && config.Timezone != "UTC" {
+		return nil, fmt.Errorf("the %s driver computes the buckets in UTC, got timezone %q", DRIVER_SQLITE, config.Timezone)
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_time_format", "sqlite")
+
+	db, err := sql.Open("sqlite", "file:"+config.Url+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	return db, nil
+}
+
+// utcArgs converts the timestamps of the query arguments to UTC. SQLite stores
+// timestamps as text, so the same instant must always be written with the
+// same offset to be compared and sorted correctly.
+func utcArgs(args []interface{}) []interface{} {
+	for i, arg := range args {
+		if ts, ok := arg.(time.Time); ok {
+			args[i] = ts.UTC()
+		}
+	}
+	return args
+}
@@ -0,0 +1,158 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// A lastValue is the last value of a label written to the database
+type lastValue struct {
+	value   string    // normalized value
+	written time.Time // timestamp of the value
+}
+
+// A dedupSeed describes how to retrieve the last stored value of a label
+type dedupSeed struct {
+	query string        // SQL query returning the timestamp and value
+	args  []interface{} // query arguments
+}
+
+const (
+	// SQL Queries to retrieve the last stored value of a label
+	SelectLastCurrentQuery        string = `SELECT timestamp, current FROM current WHERE phase = $1 ORDER BY timestamp DESC LIMIT 1`
+	SelectLastPowerQuery          string = `SELECT timestamp, power FROM power ORDER BY timestamp DESC LIMIT 1`
+	SelectLastEnergyQuery         string = `SELECT timestamp, reading FROM energy WHERE tariff = $1 ORDER BY timestamp DESC LIMIT 1`
+	SelectLastInjectedEnergyQuery string = `SELECT timestamp, reading FROM injected_energy ORDER BY timestamp DESC LIMIT 1`
+	SelectLastInjectedPowerQuery  string = `SELECT timestamp, power FROM injected_power ORDER BY timestamp DESC LIMIT 1`
+	SelectLastReactiveEnergyQuery string = `SELECT timestamp, reading FROM reactive_energy WHERE quadrant = $1 ORDER BY timestamp DESC LIMIT 1`
+	SelectLastVoltageQuery        string = `SELECT timestamp, voltage FROM voltage WHERE phase = $1 AND kind = $2 ORDER BY timestamp DESC LIMIT 1`
+)
+
+// dedupSeeds lists the labels that support change-only storage. The RMS
+// voltages are not part of it since the sag / swell detector needs every
+// reading.
+var dedupSeeds map[string]dedupSeed = map[string]dedupSeed{
+	"IINST":  {SelectLastCurrentQuery, []interface{}{0}},
+	"IINST1": {SelectLastCurrentQuery, []interface{}{1}},
+	"IINST2": {SelectLastCurrentQuery, []interface{}{2}},
+	"IINST3": {SelectLastCurrentQuery, []interface{}{3}},
+	"PAPP":   {SelectLastPowerQuery, nil},
+	"BASE":   {SelectLastEnergyQuery, []interface{}{"BASE"}},
+	"HCHP":   {SelectLastEnergyQuery, []interface{}{"HCHP"}},
+	"HCHC":   {SelectLastEnergyQuery, []interface{}{"HCHC"}},
+	"EAST":   {SelectLastEnergyQuery, []interface{}{"EAST"}},
+	"EASF01": {SelectLastEnergyQuery, []interface{}{"EASF01"}},
+	"EASF02": {SelectLastEnergyQuery, []interface{}{"EASF02"}},
+	"EASF03": {SelectLastEnergyQuery, []interface{}{"EASF03"}},
+	"EASF04": {SelectLastEnergyQuery, []interface{}{"EASF04"}},
+	"EASF05": {SelectLastEnergyQuery, []interface{}{"EASF05"}},
+	"EASF06": {SelectLastEnergyQuery, []interface{}{"EASF06"}},
+	"EASF07": {SelectLastEnergyQuery, []interface{}{"EASF07"}},
+	"EASF08": {SelectLastEnergyQuery, []interface{}{"EASF08"}},
+	"EASF09": {SelectLastEnergyQuery, []interface{}{"EASF09"}},
+	"EASF10": {SelectLastEnergyQuery, []interface{}{"EASF10"}},
+	"EAIT":   {SelectLastInjectedEnergyQuery, nil},
+	"SINSTI": {SelectLastInjectedPowerQuery, nil},
+	"ERQ1":   {SelectLastReactiveEnergyQuery, []interface{}{1}},
+	"ERQ2":   {SelectLastReactiveEnergyQuery, []interface{}{2}},
+	"ERQ3":   {SelectLastReactiveEnergyQuery, []interface{}{3}},
+	"ERQ4":   {SelectLastReactiveEnergyQuery, []interface{}{4}},
+	"UMOY1":  {SelectLastVoltageQuery, []interface{}{1, "UMOY"}},
+	"UMOY2":  {SelectLastVoltageQuery, []interface{}{2, "UMOY"}},
+	"UMOY3":  {SelectLastVoltageQuery, []interface{}{3, "UMOY"}},
+}
+
+// normalizeValue returns the canonical form of the value of a message so
+// that "007" and "7" are considered equal
+func normalizeValue(msg TicMessage) string {
+	if n, err := msg.Integer(); err == nil {
+		return strconv.FormatInt(n, 10)
+	}
+	return msg.Value
+}
+
+// seedDedupCache loads the last stored value of each label configured for
+// change-only storage
+func (processor *Processor) seedDedupCache() error {
+	for label := range processor.Config.Dedup {
+		seed, ok := dedupSeeds[label]
+		if !ok {
+			return fmt.Errorf("change-only storage is not supported for %s", label)
+		}
+
+		var ts time.Time
+		var value int64
+		err := processor.retryWrite(func() error {
+			return processor.conn.QueryRow(seed.query, seed.args...).Scan(&ts, &value)
+		})
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		processor.lastValues[label] = lastValue{
+			value:   strconv.FormatInt(value, 10),
+			written: ts,
+		}
+	}
+
+	return nil
+}
+
+// isDuplicate returns true if the label is configured for change-only
+// storage and the message carries the same value as the last one written,
+// within the heartbeat interval.
+func (processor *Processor) isDuplicate(msg TicMessage) bool {
+	heartbeat, ok := processor.Config.Dedup[msg.Field]
+	if !ok {
+		return false
+	}
+
+	last, ok := processor.lastValues[msg.Field]
+	if !ok || last.value != normalizeValue(msg) {
+		return false
+	}
+
+	return heartbeat == 0 || time.Time(msg.Timestamp).Sub(last.written) < heartbeat
+}
+
+// recordWrite updates the last written value of a label configured for
+// change-only storage, once the database sink confirmed the write
+func (processor *Processor) recordWrite(msg TicMessage) {
+	if _, ok := processor.Config.Dedup[msg.Field]; !ok {
+		return
+	}
+	if last, ok := processor.lastValues[msg.Field]; ok && time.Time(msg.Timestamp).Before(last.written) {
+		return
+	}
+
+	processor.lastValues[msg.Field] = lastValue{
+		value:   normalizeValue(msg),
+		written: time.Time(msg.Timestamp),
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"fmt"
+	"time"
+)
+
+// A DownsampleConfig stores the settings of the downsampling of high-rate
+// labels
+type DownsampleConfig struct {
+	Windows map[string]time.Duration // aggregation window, by label
+	KeepRaw bool                     // whether to store every raw sample in addition to the aggregates
+}
+
+// A sampleWindow accumulates the samples of a label within a window
+type sampleWindow struct {
+	start    time.Time // beginning of the window
+	min      int64     // lowest value
+	max      int64     // highest value
+	sum      int64     // sum of the values
+	last     int64     // most recent value
+	lastTs   time.Time // timestamp of the most recent value
+	samples  int64     // number of samples
+	received time.Time // reception time of the last sample
+}
+
+// downsampleLabels lists the high-rate labels that support downsampling
+var downsampleLabels map[string]bool = map[string]bool{
+	"IINST":  true,
+	"IINST1": true,
+	"IINST2": true,
+	"IINST3": true,
+	"PAPP":   true,
+	"SINSTI": true,
+}
+
+// checkDownsampleConfig returns an error if downsampling is configured for
+// an unsupported label
+func (processor *Processor) checkDownsampleConfig() error {
+	for label, window := range processor.Config.Downsample.Windows {
+		if !downsampleLabels[label] {
+			return fmt.Errorf("downsampling is not supported for %s", label)
+		}
+		if window <= 0 {
+			return fmt.Errorf("invalid downsampling window for %s: %s", label, window)
+		}
+	}
+	return nil
+}
+
+// downsample adds the message to the current window of its label. It returns
+// true if the label is downsampled. Samples older than the current window are
+// dropped since their window has already been saved.
+func (processor *Processor) downsample(msg TicMessage) (bool, error) {
+	window, ok := processor.Config.Downsample.Windows[msg.Field]
+	if !ok {
+		return false, nil
+	}
+
+	value, err := msg.Integer()
+	if err != nil {
+		return true, err
+	}
+
+	ts := time.Time(msg.Timestamp)
+	start := ts.Truncate(window)
+	w, ok := processor.windows[msg.Field]
+	if (ok && start.Before(w.start)) || ts.Before(processor.savedWindows[msg.Field]) {
+		return true, fmt.Errorf("dropped late sample %s = %s at %s: its window has already been saved", msg.Field, msg.Value, ts.Format(time.RFC3339))
+	}
+	if ok && !w.start.Equal(start) {
+		delete(processor.windows, msg.Field)
+		if err := processor.saveWindow(msg.Field, w); err != nil {
+			return true, err
+		}
+		ok = false
+	}
+
+	if !ok {
+		w = &sampleWindow{start: start, min: value, max: value}
+		processor.windows[msg.Field] = w
+	}
+
+	if value < w.min {
+		w.min = value
+	}
+	if value > w.max {
+		w.max = value
+	}
+	if !ts.Before(w.lastTs) {
+		w.last = value
+		w.lastTs = ts
+	}
+	w.sum += value
+	w.samples++
+	w.received = msg.Received
+	if w.received.IsZero() {
+		w.received = time.Now()
+	}
+
+	return true, nil
+}
+
+// flushWindows saves the windows whose label has not been received for a
+// whole window. The windows of a label that is still received are saved by
+// downsample, when a sample opens the next window: a message of another label
+// with a skewed timestamp cannot close them.
+func (processor *Processor) flushWindows(now time.Time) {
+	for label, w := range processor.windows {
+		if now.Sub(w.received) < processor.Config.Downsample.Windows[label] {
+			continue
+		}
+
+		delete(processor.windows, label)
+		if err := processor.saveWindow(label, w); err != nil {
+			processor.Config.Logger.Println(err)
+		}
+	}
+}
+
+// saveAllWindows saves the on-going windows, at shutdown
+func (processor *Processor) saveAllWindows() {
+	for label, w := range processor.windows {
+		delete(processor.windows, label)
+		if err := processor.saveWindow(label, w); err != nil {
+			processor.Config.Logger.Println(err)
+		}
+	}
+}
+
+// saveWindow sends the aggregates of a window to the sinks
+func (processor *Processor) saveWindow(label string, w *sampleWindow) error {
+	processor.savedWindows[label] = w.start.Add(processor.Config.Downsample.Windows[label])
+	processor.emit(Point{
+		Table:     "downsampled",
+		Timestamp: w.start,
+		Tags:      map[string]string{"label": label},
+		Fields: map[string]interface{}{
+			"min":     w.min,
+			"avg":     float64(w.sum) / float64(w.samples),
+			"max":     w.max,
+			"last":    w.last,
+			"samples": w.samples,
+		},
+	})
+	return nil
+}
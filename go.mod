@@ -4,9 +4,12 @@ go 1.16
 
 require (
 	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/golang/snappy v0.0.4
 	github.com/jackc/pgx/v4 v4.15.0
 	github.com/pressly/goose/v3 v3.5.3
 	github.com/rubenv/sql-migrate v1.1.1
 	github.com/spf13/cobra v1.3.0
 	github.com/spf13/viper v1.10.1
+	google.golang.org/protobuf v1.27.1
+	modernc.org/sqlite v1.14.6
 )
@@ -0,0 +1,213 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An InfluxConfig stores where to send the InfluxDB line protocol
+type InfluxConfig struct {
+	Url     string        // base URL of the InfluxDB v2 server (optional)
+	Org     string        // organization owning the bucket
+	Bucket  string        // bucket receiving the points
+	Token   string        // API token
+	File    string        // file to append the line protocol to, "-" for stdout (optional)
+	Meter   string        // value of the meter tag
+	Timeout time.Duration // how much time to wait for the server to respond
+}
+
+// An InfluxSink writes points as InfluxDB line protocol, either to the
+// InfluxDB v2 HTTP write API or to a file
+type InfluxSink struct {
+	config InfluxConfig      // the configuration
+	client http.Client       // the HTTP client
+	file   *os.File          // the output file, when writing to a file
+	writer *bufio.Writer     // buffers the output file
+	escape *strings.Replacer // escapes measurements, tag keys and tag values
+}
+
+// NewInfluxSink creates a sink writing line protocol to the configured
+// destination
+func NewInfluxSink(config InfluxConfig) (*InfluxSink, error) {
+	sink := InfluxSink{
+		config: config,
+		client: http.Client{Timeout: config.Timeout},
+		escape: strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`),
+	}
+
+	switch {
+	case config.File == "-":
+		sink.file = os.Stdout
+	case config.File != "":
+		f, err := os.OpenFile(config.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		sink.file = f
+	case config.Url == "":
+		return nil, fmt.Errorf("influxdb: either an URL or a file is required")
+	}
+	if sink.file != nil {
+		sink.writer = bufio.NewWriter(sink.file)
+	}
+
+	return &sink, nil
+}
+
+// appendLine encodes a point as line protocol:
+// measurement,tag=value field=1i timestamp
+func (sink *InfluxSink) appendLine(buf *bytes.Buffer, point Point) {
+	buf.WriteString(sink.escape.Replace(point.Table))
+
+	tags := make(map[string]string, len(point.Tags)+1)
+	for k, v := range point.Tags {
+		tags[k] = v
+	}
+	if sink.config.Meter != "" {
+		tags["meter"] = sink.config.Meter
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteByte(',')
+		buf.WriteString(sink.escape.Replace(k))
+		buf.WriteByte('=')
+		buf.WriteString(sink.escape.Replace(tags[k]))
+	}
+
+	keys = keys[:0]
+	for k := range point.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if i == 0 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(sink.escape.Replace(k))
+		buf.WriteByte('=')
+		switch v := point.Fields[k].(type) {
+		case int64:
+			buf.WriteString(strconv.FormatInt(v, 10))
+			buf.WriteByte('i')
+		case float64:
+			buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		default:
+			fmt.Fprintf(buf, "%q", fmt.Sprint(v))
+		}
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(point.Timestamp.UnixNano(), 10))
+	buf.WriteByte('\n')
+}
+
+// Write sends a batch of points to the InfluxDB server or appends them to
+// the file
+func (sink *InfluxSink) Write(points []Point) error {
+	var buf bytes.Buffer
+	for _, point := range points {
+		sink.appendLine(&buf, point)
+	}
+
+	if sink.writer != nil {
+		_, err := sink.writer.Write(buf.Bytes())
+		return err
+	}
+
+	return sink.post(buf.Bytes())
+}
+
+// post sends line protocol to the InfluxDB v2 write API
+func (sink *InfluxSink) post(payload []byte) error {
+	params := url.Values{}
+	params.Set("org", sink.config.Org)
+	params.Set("bucket", sink.config.Bucket)
+	params.Set("precision", "ns")
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(sink.config.Url, "/")+"/api/v2/write?"+params.Encode(), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if sink.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+sink.config.Token)
+	}
+
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("influxdb: unexpected status %s", resp.Status)
+		if body, _ := io.ReadAll(io.LimitReader(resp.Body, 512)); len(bytes.TrimSpace(body)) > 0 {
+			err = fmt.Errorf("%s: %s", err, bytes.TrimSpace(body))
+		}
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return TransientError{err}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Flush writes the buffered lines to the file
+func (sink *InfluxSink) Flush() error {
+	if sink.writer == nil {
+		return nil
+	}
+	return sink.writer.Flush()
+}
+
+// Close flushes the buffered lines and closes the file
+func (sink *InfluxSink) Close() error {
+	if sink.file == nil {
+		return nil
+	}
+
+	err := sink.Flush()
+	if sink.file != os.Stdout {
+		if closeErr := sink.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
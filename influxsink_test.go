@@ -0,0 +1,112 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// influxTestPoints returns points exercising the tag ordering, the escaping
+// and the field types of the line protocol
+func influxTestPoints() []Point {
+	ts := time.Unix(1600000000, 5*int64(time.Millisecond))
+	return []Point{
+		{Table: "voltage", Timestamp: ts, Tags: map[string]string{"phase": "1", "kind": "URMS"}, Fields: map[string]interface{}{"voltage": int64(231)}},
+		{Table: "downsampled", Timestamp: ts, Tags: map[string]string{"label": "PAPP"}, Fields: map[string]interface{}{"max": int64(2), "avg": 1.5}},
+	}
+}
+
+// influxTestLines is the line protocol of influxTestPoints for the meter "my meter,1"
+const influxTestLines = `voltage,kind=URMS,meter=my\ meter\,1,phase=1 voltage=231i 1600000000005000000
+downsampled,label=PAPP,meter=my\ meter\,1 avg=1.5,max=2i 1600000000005000000
+`
+
+func TestInfluxSinkHttp(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v2/write" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		query := r.URL.Query()
+		if query.Get("org") != "home" || query.Get("bucket") != "tic" || query.Get("precision") != "ns" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		if r.Header.Get("Authorization") != "Token s3cr3t" {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewInfluxSink(InfluxConfig{
+		Url:     server.URL + "/",
+		Org:     "home",
+		Bucket:  "tic",
+		Token:   "s3cr3t",
+		Meter:   "my meter,1",
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write(influxTestPoints()); err != nil {
+		t.Fatal(err)
+	}
+	if body != influxTestLines {
+		t.Errorf("expected:\n%s\ngot:\n%s", influxTestLines, body)
+	}
+}
+
+func TestInfluxSinkFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tic.lp")
+	sink, err := NewInfluxSink(InfluxConfig{File: path, Meter: "my meter,1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write(influxTestPoints()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != influxTestLines {
+		t.Errorf("expected:\n%s\ngot:\n%s", influxTestLines, b)
+	}
+}
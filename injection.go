@@ -0,0 +1,72 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"time"
+)
+
+// processInjectedEnergy saves the injected energy index (EAIT) to the database
+func (processor *Processor) processInjectedEnergy(msg TicMessage) error {
+	value, err := msg.Integer()
+	if err != nil {
+		return err
+	}
+
+	return processor.store(msg, Point{
+		Table:     "injected_energy",
+		Timestamp: time.Time(msg.Timestamp),
+		Fields:    map[string]interface{}{"reading": value},
+	})
+}
+
+// processInjectedPower saves the instantaneous injected power (SINSTI) to
+// the database
+func (processor *Processor) processInjectedPower(msg TicMessage) error {
+	value, err := msg.Integer()
+	if err != nil {
+		return err
+	}
+
+	return processor.store(msg, Point{
+		Table:     "injected_power",
+		Timestamp: time.Time(msg.Timestamp),
+		Fields:    map[string]interface{}{"power": value},
+	})
+}
+
+// processReactiveEnergy saves the reactive energy indexes (ERQ1-4) to the
+// database
+func (processor *Processor) processReactiveEnergy(msg TicMessage) error {
+	quadrant := msg.Field[3:]
+	value, err := msg.Integer()
+	if err != nil {
+		return err
+	}
+
+	return processor.store(msg, Point{
+		Table:     "reactive_energy",
+		Timestamp: time.Time(msg.Timestamp),
+		Tags:      map[string]string{"quadrant": quadrant},
+		Fields:    map[string]interface{}{"reading": value},
+	})
+}
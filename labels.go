@@ -0,0 +1,162 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Those flags define the type of the value of a TIC label
+const (
+	LABEL_INTEGER  = "integer"  // decimal integer
+	LABEL_STRING   = "string"   // free text
+	LABEL_BITFIELD = "bitfield" // hexadecimal bitfield
+	LABEL_HORODATE = "horodate" // decimal integer, optionally preceded by its horodate
+)
+
+// A Label describes a TIC label
+type Label struct {
+	Type string // one of the LABEL_* flags
+	Unit string // unit of the value, if any
+}
+
+// TicLabels is the catalogue of the TIC labels handled by the processor
+var TicLabels map[string]Label = map[string]Label{
+	"IINST":    {LABEL_INTEGER, "A"},
+	"IINST1":   {LABEL_INTEGER, "A"},
+	"IINST2":   {LABEL_INTEGER, "A"},
+	"IINST3":   {LABEL_INTEGER, "A"},
+	"PAPP":     {LABEL_INTEGER, "VA"},
+	"BASE":     {LABEL_INTEGER, "Wh"},
+	"HCHP":     {LABEL_INTEGER, "Wh"},
+	"HCHC":     {LABEL_INTEGER, "Wh"},
+	"EAST":     {LABEL_INTEGER, "Wh"},
+	"EASF01":   {LABEL_INTEGER, "Wh"},
+	"EASF02":   {LABEL_INTEGER, "Wh"},
+	"EASF03":   {LABEL_INTEGER, "Wh"},
+	"EASF04":   {LABEL_INTEGER, "Wh"},
+	"EASF05":   {LABEL_INTEGER, "Wh"},
+	"EASF06":   {LABEL_INTEGER, "Wh"},
+	"EASF07":   {LABEL_INTEGER, "Wh"},
+	"EASF08":   {LABEL_INTEGER, "Wh"},
+	"EASF09":   {LABEL_INTEGER, "Wh"},
+	"EASF10":   {LABEL_INTEGER, "Wh"},
+	"ADPS":     {LABEL_INTEGER, "A"},
+	"ADIR1":    {LABEL_INTEGER, "A"},
+	"ADIR2":    {LABEL_INTEGER, "A"},
+	"ADIR3":    {LABEL_INTEGER, "A"},
+	"ISOUSC":   {LABEL_INTEGER, "A"},
+	"PREF":     {LABEL_INTEGER, "kVA"},
+	"STGE":     {LABEL_BITFIELD, ""},
+	"EAIT":     {LABEL_INTEGER, "Wh"},
+	"SINSTI":   {LABEL_INTEGER, "VA"},
+	"ERQ1":     {LABEL_INTEGER, "VArh"},
+	"ERQ2":     {LABEL_INTEGER, "VArh"},
+	"ERQ3":     {LABEL_INTEGER, "VArh"},
+	"ERQ4":     {LABEL_INTEGER, "VArh"},
+	"URMS1":    {LABEL_INTEGER, "V"},
+	"URMS2":    {LABEL_INTEGER, "V"},
+	"URMS3":    {LABEL_INTEGER, "V"},
+	"UMOY1":    {LABEL_HORODATE, "V"},
+	"UMOY2":    {LABEL_HORODATE, "V"},
+	"UMOY3":    {LABEL_HORODATE, "V"},
+	"MSG1":     {LABEL_STRING, ""},
+	"MSG2":     {LABEL_STRING, ""},
+	"PRM":      {LABEL_STRING, ""},
+	"RELAIS":   {LABEL_INTEGER, ""},
+	"NJOURF":   {LABEL_STRING, ""},
+	"NJOURF+1": {LABEL_STRING, ""},
+	"PJOURF+1": {LABEL_STRING, ""},
+}
+
+// topicLabels maps the MQTT topic names of the labels that contain a "+",
+// which MQTT forbids in topic names, to the TIC labels
+var topicLabels map[string]string = map[string]string{
+	"NJOURF1":  "NJOURF+1",
+	"NJOURF_1": "NJOURF+1",
+	"PJOURF1":  "PJOURF+1",
+	"PJOURF_1": "PJOURF+1",
+}
+
+// LabelFromTopic returns the TIC label published on the last segment of an
+// MQTT topic and whether it is handled by the processor
+func LabelFromTopic(segment string) (string, bool) {
+	if label, ok := topicLabels[segment]; ok {
+		return label, true
+	}
+	_, ok := TicLabels[segment]
+	return segment, ok
+}
+
+// ParseHorodate decodes a TIC horodate "SAAMMJJhhmmss" where S is the season
+// (E for summer time, H for winter time, lowercase when the clock is
+// degraded, space when unknown).
+func ParseHorodate(horodate string) (time.Time, error) {
+	if len(horodate) != 13 {
+		return time.Time{}, fmt.Errorf("invalid horodate %q", horodate)
+	}
+
+	var offset int
+	switch horodate[0] {
+	case 'E', 'e':
+		offset = 2 * 3600
+	case 'H', 'h', ' ':
+		offset = 3600
+	default:
+		return time.Time{}, fmt.Errorf("invalid horodate season %q", horodate[0])
+	}
+
+	ts, err := time.ParseInLocation("060102150405", horodate[1:], time.FixedZone("", offset))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid horodate %q", horodate)
+	}
+	return ts, nil
+}
+
+// Integer returns the value of the message as a 64-bit integer. For horodated
+// labels, the horodate preceding the value, if any, is skipped.
+func (msg TicMessage) Integer() (int64, error) {
+	value := msg.Value
+	label := TicLabels[msg.Field]
+	switch label.Type {
+	case LABEL_INTEGER:
+	case LABEL_HORODATE:
+		if fields := strings.Fields(value); len(fields) == 2 {
+			// the season is a space when unknown and gets split off
+			horodate := fields[0]
+			if len(horodate) == 12 {
+				horodate = " " + horodate
+			}
+			if _, err := ParseHorodate(horodate); err != nil {
+				return 0, err
+			}
+			value = fields[1]
+		}
+	default:
+		return 0, fmt.Errorf("%s is not an integer label", msg.Field)
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+}
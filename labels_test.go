@@ -0,0 +1,60 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHorodate(t *testing.T) {
+	summer := time.FixedZone("", 2*3600)
+	winter := time.FixedZone("", 3600)
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{"E220615120000", time.Date(2022, 6, 15, 12, 0, 0, 0, summer)},
+		{"e220615120000", time.Date(2022, 6, 15, 12, 0, 0, 0, summer)},
+		{"H221225083015", time.Date(2022, 12, 25, 8, 30, 15, 0, winter)},
+		{"h221225083015", time.Date(2022, 12, 25, 8, 30, 15, 0, winter)},
+		{" 220101000000", time.Date(2022, 1, 1, 0, 0, 0, 0, winter)},
+	}
+	for _, test := range tests {
+		ts, err := ParseHorodate(test.input)
+		if err != nil {
+			t.Errorf("%q: %s", test.input, err)
+			continue
+		}
+		if !ts.Equal(test.want) {
+			t.Errorf("%q: got %s, want %s", test.input, ts, test.want)
+		}
+	}
+}
+
+func TestParseHorodateErrors(t *testing.T) {
+	for _, input := range []string{"", "E22061512000", "E2206151200000", "X220615120000", "E221315120000", "E220615250000", "E2206151200AB"} {
+		if ts, err := ParseHorodate(input); err == nil {
+			t.Errorf("%q: expected an error, got %s", input, ts)
+		}
+	}
+}
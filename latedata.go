@@ -0,0 +1,79 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"fmt"
+	"time"
+)
+
+// Those flags define how to handle a value whose timestamp already exists in
+// the database
+const (
+	CONFLICT_OVERWRITE  = "overwrite"            // the new value replaces the existing one (default)
+	CONFLICT_KEEP_FIRST = "keep-first"           // the existing value is kept
+	CONFLICT_REJECT_OLD = "reject-if-older-than" // values received too long after their timestamp are dropped, the others overwrite
+	CONFLICT_KEEP_MAX   = "keep-max"             // the highest value is kept (energy indexes only)
+)
+
+// A ConflictPolicy defines how to handle late and duplicate values in a table
+type ConflictPolicy struct {
+	Policy string        // one of the CONFLICT_* flags
+	MaxAge time.Duration // for CONFLICT_REJECT_OLD, how old a value can be when received
+}
+
+// A LateDataConfig stores how to handle late and out-of-order values
+type LateDataConfig struct {
+	Conflicts map[string]ConflictPolicy // conflict policy, by table
+	MaxFuture time.Duration             // how far in the future of the receive time a timestamp can be (0 = no limit)
+}
+
+// checkClock returns an error if the timestamp of the message is too far in
+// the future of its receive time
+func (processor *Processor) checkClock(msg TicMessage) error {
+	maxFuture := processor.Config.LateData.MaxFuture
+	if maxFuture == 0 || msg.Received.IsZero() {
+		return nil
+	}
+
+	if skew := time.Time(msg.Timestamp).Sub(msg.Received); skew > maxFuture {
+		return fmt.Errorf("rejected %s: timestamp is %s in the future", msg.Field, skew)
+	}
+
+	return nil
+}
+
+// store sends a raw value to the sinks, according to the conflict policy of
+// its table
+func (processor *Processor) store(msg TicMessage, point Point) error {
+	conflict := processor.Config.LateData.Conflicts[point.Table]
+	if conflict.Policy == CONFLICT_REJECT_OLD && !msg.Received.IsZero() {
+		if age := msg.Received.Sub(time.Time(msg.Timestamp)); age > conflict.MaxAge {
+			return fmt.Errorf("rejected %s: value is %s old", msg.Field, age)
+		}
+	}
+
+	point.Label = msg.Field
+	point.source = &msg
+	processor.emit(point)
+	return nil
+}
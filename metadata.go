@@ -0,0 +1,140 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A TariffSwitchover is one entry of the next day tariff profile (PJOURF+1)
+type TariffSwitchover struct {
+	Time   string // switchover time (HH:MM)
+	Index  int    // supplier tariff index starting at that time
+	Action int    // raw action code
+}
+
+const (
+	// SQL Query to store a new metadata value
+	UpsertMetadataQuery string = `
+	INSERT INTO meter_metadata VALUES ($1, $2, $3)
+	ON CONFLICT (timestamp, label) DO UPDATE
+    SET value = excluded.value`
+
+	// SQL Query to retrieve the last known value of a metadata
+	SelectLastMetadataQuery string = `
+	SELECT value FROM meter_metadata WHERE label = $1 ORDER BY timestamp DESC LIMIT 1`
+
+	// SQL Query to store a tariff switchover
+	UpsertTariffProfileQuery string = `
+	INSERT INTO tariff_profile VALUES ($1, $2, $3, $4)
+	ON CONFLICT (timestamp, switchover) DO UPDATE
+    SET tariff_index = excluded.tariff_index, action = excluded.action`
+)
+
+// ParseTariffProfile decodes the next day tariff profile (PJOURF+1). It is
+// made of up to eleven blocks "HHMMSSSS" separated by spaces, where HHMM is
+// the switchover time and SSSS the action code whose lowest four bits hold
+// the tariff index. Unused blocks are filled with "NONUTILE".
+func ParseTariffProfile(value string) ([]TariffSwitchover, error) {
+	var profile []TariffSwitchover
+	for _, block := range strings.Fields(value) {
+		if block == "NONUTILE" {
+			continue
+		}
+		if len(block) != 8 {
+			return nil, fmt.Errorf("invalid tariff profile block %q", block)
+		}
+
+		hours, err := strconv.Atoi(block[0:2])
+		if err != nil {
+			return nil, err
+		}
+		minutes, err := strconv.Atoi(block[2:4])
+		if err != nil {
+			return nil, err
+		}
+		action, err := strconv.ParseUint(block[4:8], 16, 16)
+		if err != nil {
+			return nil, err
+		}
+		if hours > 23 || minutes > 59 {
+			return nil, fmt.Errorf("invalid tariff profile block %q", block)
+		}
+
+		profile = append(profile, TariffSwitchover{
+			Time:   fmt.Sprintf("%02d:%02d", hours, minutes),
+			Index:  int(action & 0x0F),
+			Action: int(action),
+		})
+	}
+
+	return profile, nil
+}
+
+// processMetadata saves the supplier messages (MSG1, MSG2), the delivery
+// point id (PRM), the virtual relays (RELAIS) and the tariff calendar
+// (NJOURF, NJOURF+1, PJOURF+1) when they differ from the last known value.
+func (processor *Processor) processMetadata(msg TicMessage) error {
+	last, ok := processor.lastMetadata[msg.Field]
+	if !ok {
+		err := processor.retryWrite(func() error {
+			return processor.conn.QueryRow(SelectLastMetadataQuery, msg.Field).Scan(&last)
+		})
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		ok = err == nil
+	}
+	if ok && last == msg.Value {
+		processor.lastMetadata[msg.Field] = last
+		return nil
+	}
+
+	var profile []TariffSwitchover
+	if msg.Field == "PJOURF+1" {
+		var err error
+		profile, err = ParseTariffProfile(msg.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	processor.saveMetadata(msg, profile)
+	processor.lastMetadata[msg.Field] = msg.Value
+	return nil
+}
+
+// saveMetadata stores a metadata value and its parsed tariff profile, if any,
+// in a single transaction
+func (processor *Processor) saveMetadata(msg TicMessage, profile []TariffSwitchover) {
+	ts := time.Time(msg.Timestamp)
+	queries := []statement{{UpsertMetadataQuery, []interface{}{ts, msg.Field, msg.Value}}}
+	for _, switchover := range profile {
+		queries = append(queries, statement{UpsertTariffProfileQuery, []interface{}{ts, switchover.Time, switchover.Index, switchover.Action}})
+	}
+
+	processor.queueStatements(queries...)
+}
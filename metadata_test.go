@@ -0,0 +1,61 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTariffProfile(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []TariffSwitchover
+	}{
+		{"", nil},
+		{"NONUTILE NONUTILE", nil},
+		{"00004001 06004002 22004001 NONUTILE NONUTILE NONUTILE NONUTILE NONUTILE NONUTILE NONUTILE NONUTILE", []TariffSwitchover{
+			{Time: "00:00", Index: 1, Action: 0x4001},
+			{Time: "06:00", Index: 2, Action: 0x4002},
+			{Time: "22:00", Index: 1, Action: 0x4001},
+		}},
+		{"2359C00A", []TariffSwitchover{{Time: "23:59", Index: 10, Action: 0xC00A}}},
+	}
+	for _, test := range tests {
+		profile, err := ParseTariffProfile(test.input)
+		if err != nil {
+			t.Errorf("%q: %s", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(profile, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.input, profile, test.want)
+		}
+	}
+}
+
+func TestParseTariffProfileErrors(t *testing.T) {
+	for _, input := range []string{"0600400", "060040010", "24004001", "06604001", "0600XYZW", "HH004001", "00004001 NONUTIL"} {
+		if profile, err := ParseTariffProfile(input); err == nil {
+			t.Errorf("%q: expected an error, got %+v", input, profile)
+		}
+	}
+}
@@ -22,27 +22,180 @@ THE SOFTWARE.
 package lib
 
 import (
+	"context"
 	"database/sql"
 	"embed"
+	"fmt"
 
+	"github.com/jackc/pgx/v4"
 	goose "github.com/pressly/goose/v3"
 )
 
+// Those flags define how the processor handles schema migrations at startup
+const (
+	MIGRATE_AUTO  = "auto"  // migrate the schema, holding a lock so that only one instance migrates
+	MIGRATE_CHECK = "check" // refuse to start if the schema is not up-to-date
+	MIGRATE_SKIP  = "skip"  // do nothing
+)
+
+// MIGRATION_LOCK_ID is the PostgreSQL advisory lock held during migrations
+// ("tic-tsdb" in ASCII)
+const MIGRATION_LOCK_ID int64 = 0x7469632d74736462
+
 // SqlMigrationFS stores a list of database schema migration scripts
-//go:embed schemas/*.sql
+//go:embed schemas/*.sql schemas/postgres/*.sql schemas/sqlite/*.sql
 var SqlMigrationFS embed.FS
 
-// MigrateDb migrates the provided database to the most recent schema
-func MigrateDb(db *sql.DB) error {
+// A Migrator migrates the schema of a database and manages its policies. It
+// configures goose for its database backend and target schema before each
+// use.
+type Migrator struct {
+	db     *sql.DB // the database
+	driver string  // database backend (DRIVER_POSTGRES or DRIVER_SQLITE)
+	schema string  // schema in which the tables are created, empty for the default search_path
+}
+
+// NewMigrator creates a migrator targeting the schema of the database
+func NewMigrator(db *sql.DB, driver, schema string) *Migrator {
+	if driver == "" {
+		driver = DRIVER_POSTGRES
+	}
+	return &Migrator{db: db, driver: driver, schema: schema}
+}
+
+// versionTable returns the name of the goose version table, in the target
+// schema if any
+func (m *Migrator) versionTable() string {
+	if m.schema == "" {
+		return "goose_db_version"
+	}
+	return pgx.Identifier{m.schema, "goose_db_version"}.Sanitize()
+}
+
+// CreateDbSchema creates the target schema, if any
+func (m *Migrator) CreateDbSchema() error {
+	if m.schema == "" {
+		return nil
+	}
+
+	_, err := m.db.Exec("CREATE SCHEMA IF NOT EXISTS " + pgx.Identifier{m.schema}.Sanitize())
+	return err
+}
+
+// SetupGoose configures the goose library to use the embedded migrations of
+// the database backend and the version table of the target schema, and
+// returns the directory holding the migrations. PostgreSQL servers without
+// the TimescaleDB extension get their own migrations.
+func (m *Migrator) SetupGoose() (string, error) {
 	goose.SetBaseFS(SqlMigrationFS)
+	goose.SetTableName(m.versionTable())
+	if m.driver == DRIVER_SQLITE {
+		return "schemas/sqlite", goose.SetDialect("sqlite3")
+	}
+
+	timescale, err := m.HasTimescaleDb()
+	if err != nil {
+		return "", err
+	}
+	if !timescale {
+		return "schemas/postgres", goose.SetDialect("postgres")
+	}
+	return "schemas", goose.SetDialect("postgres")
+}
+
+// MigrateDb migrates the database to the most recent schema
+func (m *Migrator) MigrateDb() error {
+	dir, err := m.SetupGoose()
+	if err != nil {
+		return err
+	}
 
-	if err := goose.SetDialect("postgres"); err != nil {
+	if err := m.CreateDbSchema(); err != nil {
 		return err
 	}
 
-	if err := goose.Up(db, "schemas"); err != nil {
+	if err := goose.Up(m.db, dir); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// MigrateDbWithLock migrates the database to the most recent schema and
+// applies the retention and compression policies while holding an advisory
+// lock, so that concurrent instances wait for the first one to complete the
+// migration instead of racing against it. The lock is held on its own
+// connection, the pool must allow at least two. SQLite databases are local to
+// a single instance and are migrated without lock.
+func (m *Migrator) MigrateDbWithLock(policies PolicyConfig) error {
+	if m.driver == DRIVER_SQLITE {
+		if err := m.MigrateDb(); err != nil {
+			return err
+		}
+		return m.ApplyPolicies(policies)
+	}
+
+	if max := m.db.Stats().MaxOpenConnections; max > 0 && max < 2 {
+		return fmt.Errorf("automatic migrations require at least 2 open connections, got %d", max)
+	}
+
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", MIGRATION_LOCK_ID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", MIGRATION_LOCK_ID)
+
+	if err := m.MigrateDb(); err != nil {
+		return err
+	}
+	return m.ApplyPolicies(policies)
+}
+
+// CheckDbSchema returns an error if the database is not at the most recent
+// schema version. It does not require DDL rights.
+func (m *Migrator) CheckDbSchema() error {
+	dir, err := m.SetupGoose()
+	if err != nil {
+		return err
+	}
+
+	migrations, err := goose.CollectMigrations(dir, 0, goose.MaxVersion)
+	if err != nil {
+		return err
+	}
+	last, err := migrations.Last()
+	if err != nil {
+		return err
+	}
+
+	// goose.GetDBVersion creates the version table when it does not exist,
+	// so check for its existence first.
+	query := "SELECT to_regclass($1) IS NOT NULL"
+	if m.driver == DRIVER_SQLITE {
+		query = "SELECT count(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = $1"
+	}
+	var exists bool
+	err = m.db.QueryRow(query, m.versionTable()).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	var current int64
+	if exists {
+		current, err = goose.GetDBVersion(m.db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if current < last.Version {
+		return fmt.Errorf("db schema is at version %d but version %d is required, please run the migrations", current, last.Version)
+	}
+
+	return nil
+}
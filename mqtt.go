@@ -76,6 +76,8 @@ func NewMqttClient(config MqttConfig) (mqtt.Client, error) {
 	client := mqtt.NewClient(opts)
 	ct := client.Connect()
 	if !ct.WaitTimeout(config.Timeout) {
+		// stop the background connection attempts
+		client.Disconnect(0)
 		return nil, fmt.Errorf("mqtt: timeout waiting for connection")
 	}
 
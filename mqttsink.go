@@ -0,0 +1,152 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// A PublishConfig stores where to republish the validated readings
+type PublishConfig struct {
+	Mqtt   MqttConfig // connection to the MQTT broker
+	Prefix string     // root of the topic tree
+	Meter  string     // name of the meter in the topic tree
+	Retain bool       // whether the broker should retain the last reading of each topic
+}
+
+// A Reading is the JSON envelope of a republished value
+type Reading struct {
+	Value     interface{} `json:"value"`
+	Unit      string      `json:"unit"`
+	Timestamp UnixEpoch   `json:"ts"`
+}
+
+// An MqttSink republishes the validated and typed readings to a topic tree
+// such as tic/<meter>/power/apparent, for consumers like Home Assistant or
+// Node-RED.
+type MqttSink struct {
+	config PublishConfig // the configuration
+	client mqtt.Client   // the MQTT client, connected on first write
+}
+
+// NewMqttSink creates a sink republishing readings to the MQTT broker
+func NewMqttSink(config PublishConfig) (*MqttSink, error) {
+	if config.Prefix == "" || config.Meter == "" {
+		return nil, fmt.Errorf("mqtt: a topic prefix and a meter name are required to republish readings")
+	}
+
+	return &MqttSink{config: config}, nil
+}
+
+// publishTopic returns the topic of a point, relative to the meter. The topic
+// is empty for points that are not republished.
+func publishTopic(point Point) string {
+	switch point.Table {
+	case "current":
+		if phase := point.Tags["phase"]; phase != "0" {
+			return "current/" + phase
+		}
+		return "current"
+	case "power":
+		return "power/apparent"
+	case "injected_power":
+		return "power/injected"
+	case "energy":
+		return "energy/" + strings.ToLower(point.Tags["tariff"])
+	case "injected_energy":
+		return "energy/injected"
+	case "reactive_energy":
+		return "energy/reactive/q" + point.Tags["quadrant"]
+	case "voltage":
+		kind := "rms"
+		if point.Tags["kind"] == "UMOY" {
+			kind = "average"
+		}
+		return "voltage/" + kind + "/" + point.Tags["phase"]
+	}
+	return ""
+}
+
+// Write publishes a batch of readings and waits for the broker to
+// acknowledge them
+func (sink *MqttSink) Write(points []Point) error {
+	if sink.client == nil {
+		client, err := NewMqttClient(sink.config.Mqtt)
+		if err != nil {
+			return TransientError{err}
+		}
+		sink.client = client
+	}
+
+	var tokens []mqtt.Token
+	for _, point := range points {
+		topic := publishTopic(point)
+		if topic == "" {
+			continue
+		}
+		unit := TicLabels[point.Label].Unit
+
+		for _, value := range point.Fields {
+			payload, err := json.Marshal(Reading{
+				Value:     value,
+				Unit:      unit,
+				Timestamp: UnixEpoch(point.Timestamp),
+			})
+			if err != nil {
+				return err
+			}
+
+			topic := strings.Join([]string{sink.config.Prefix, sink.config.Meter, topic}, "/")
+			tokens = append(tokens, sink.client.Publish(topic, MQTT_QOS_1, sink.config.Retain, payload))
+		}
+	}
+
+	deadline := time.Now().Add(sink.config.Mqtt.Timeout)
+	for _, token := range tokens {
+		if !token.WaitTimeout(time.Until(deadline)) {
+			return TransientError{fmt.Errorf("mqtt: timeout waiting for publication")}
+		}
+		if err := token.Error(); err != nil {
+			return TransientError{err}
+		}
+	}
+
+	return nil
+}
+
+// Flush does nothing since readings are published by Write
+func (sink *MqttSink) Flush() error {
+	return nil
+}
+
+// Close disconnects from the MQTT broker
+func (sink *MqttSink) Close() error {
+	if sink.client != nil {
+		sink.client.Disconnect(uint(sink.config.Mqtt.GracePeriod / time.Millisecond))
+	}
+	return nil
+}
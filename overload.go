@@ -0,0 +1,177 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"time"
+)
+
+// An OverloadConfig stores the settings of the overload detector
+type OverloadConfig struct {
+	HoldTime time.Duration // how long without ADPS / ADIR before an overload is considered over
+}
+
+// An OverloadEvent represents a period during which the subscribed current
+// (ISOUSC) was exceeded
+type OverloadEvent struct {
+	Start    UnixEpoch  `json:"start"`
+	End      *UnixEpoch `json:"end,omitempty"`
+	Phase    int        `json:"phase"`
+	Peak     int64      `json:"peak"`
+	lastSeen time.Time  // timestamp of the last ADPS / ADIR received
+}
+
+const (
+	// Alert type sent when an overload begins
+	ALERT_OVERLOAD = "overload"
+
+	// SQL Query to store the beginning of an overload
+	InsertOverloadQuery string = `
+	INSERT INTO overload VALUES ($1, NULL, $2, $3)
+	ON CONFLICT (start_time, phase) DO UPDATE
+    SET peak = excluded.peak`
+
+	// SQL Query to update an on-going or finished overload
+	UpdateOverloadQuery string = `
+	UPDATE overload SET end_time = $3, peak = $4
+	WHERE start_time = $1 AND phase = $2`
+
+	// SQL Query to retrieve the overloads left open when the processor stopped
+	SelectOpenOverloadsQuery string = `
+	SELECT start_time, phase, peak FROM overload WHERE end_time IS NULL ORDER BY start_time`
+)
+
+// loadOverloads reloads the overloads left open by a previous run, so that
+// they are either continued by the next ADPS / ADIR or closed once the hold
+// time is elapsed. When several are open on the same phase, the older ones are
+// closed right away.
+func (processor *Processor) loadOverloads() error {
+	var events []*OverloadEvent
+	err := processor.retryWrite(func() error {
+		events = nil
+		rows, err := processor.conn.Query(SelectOpenOverloadsQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var start time.Time
+			event := &OverloadEvent{}
+			if err := rows.Scan(&start, &event.Phase, &event.Peak); err != nil {
+				return err
+			}
+			event.Start = UnixEpoch(start)
+			event.lastSeen = start
+			events = append(events, event)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if previous, ok := processor.overloads[event.Phase]; ok {
+			end := UnixEpoch(previous.lastSeen)
+			previous.End = &end
+			processor.updateOverload(previous)
+		}
+		processor.overloads[event.Phase] = event
+	}
+
+	return nil
+}
+
+// processOverload records an ADPS (single-phase) or ADIR1-3 (three-phase)
+// message. The first message opens an overload event and triggers an alert,
+// the following ones update its peak value.
+func (processor *Processor) processOverload(msg TicMessage) error {
+	phase := 0
+	if msg.Field != "ADPS" {
+		phase = int(msg.Field[4] - '0')
+	}
+	value, err := msg.Integer()
+	if err != nil {
+		return err
+	}
+
+	ts := time.Time(msg.Timestamp)
+	event, ok := processor.overloads[phase]
+	if !ok {
+		event = &OverloadEvent{
+			Start:    msg.Timestamp,
+			Phase:    phase,
+			Peak:     value,
+			lastSeen: ts,
+		}
+		processor.overloads[phase] = event
+		processor.sendAlert(Alert{
+			Type:      ALERT_OVERLOAD,
+			Timestamp: msg.Timestamp,
+			Data:      event,
+		})
+
+		processor.exec(InsertOverloadQuery, ts, phase, value)
+		return nil
+	}
+
+	if ts.After(event.lastSeen) {
+		event.lastSeen = ts
+	}
+	if value <= event.Peak {
+		return nil
+	}
+
+	event.Peak = value
+	processor.updateOverload(event)
+	return nil
+}
+
+// expireOverloads closes the overload events for which no ADPS / ADIR has
+// been received for longer than the configured hold time.
+func (processor *Processor) expireOverloads(now time.Time) {
+	for phase, event := range processor.overloads {
+		if now.Sub(event.lastSeen) <= processor.Config.Overload.HoldTime {
+			continue
+		}
+
+		end := UnixEpoch(event.lastSeen)
+		event.End = &end
+		delete(processor.overloads, phase)
+		processor.updateOverload(event)
+	}
+}
+
+// updateOverload saves the peak value and end time of an overload event
+func (processor *Processor) updateOverload(event *OverloadEvent) {
+	var end interface{}
+	if event.End != nil {
+		end = time.Time(*event.End)
+	}
+
+	processor.exec(UpdateOverloadQuery,
+		time.Time(event.Start),
+		event.Phase,
+		end,
+		event.Peak)
+}
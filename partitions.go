@@ -0,0 +1,134 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// partitionedTables lists the tables that are range partitioned by month on
+// PostgreSQL servers without the TimescaleDB extension
+var partitionedTables []string = []string{
+	"current",
+	"power",
+	"energy",
+	"injected_energy",
+	"injected_power",
+	"reactive_energy",
+	"voltage",
+	"downsampled",
+}
+
+// PARTITION_RETRY_INTERVAL is how long to wait before trying again to create
+// the partitions after a failure
+const PARTITION_RETRY_INTERVAL = time.Hour
+
+// CreatePartitions creates the monthly partitions of the partitioned tables,
+// from the month of the provided time up to ahead months later. Existing
+// partitions are left untouched. A failure on a partition does not prevent
+// the creation of the others: the first error is returned at the end.
+func CreatePartitions(db *sql.DB, from time.Time, ahead int) error {
+	from = from.UTC()
+	month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var firstErr error
+	for i := 0; i <= ahead; i++ {
+		start := month.AddDate(0, i, 0)
+		for _, table := range partitionedTables {
+			if err := createPartition(db, table, start, start.AddDate(0, 1, 0)); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// createPartition creates the partition of a table for a month. The values
+// of that month already stored in the default partition are moved to the new
+// partition, since PostgreSQL refuses to create it otherwise.
+func createPartition(db *sql.DB, table string, start, end time.Time) error {
+	partition := fmt.Sprintf("%s_p%04d_%02d", table, start.Year(), start.Month())
+
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass($1) IS NOT NULL", partition).Scan(&exists); err != nil {
+		return fmt.Errorf("%s: %w", partition, err)
+	}
+	if exists {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: %w", partition, err)
+	}
+	defer tx.Rollback()
+
+	parent := pgx.Identifier{table}.Sanitize()
+	defaultPartition := pgx.Identifier{table + "_default"}.Sanitize()
+	inMonth := fmt.Sprintf("timestamp >= '%s' AND timestamp < '%s'", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	queries := []string{
+		fmt.Sprintf("CREATE TEMPORARY TABLE moved ON COMMIT DROP AS SELECT * FROM %s WHERE %s", defaultPartition, inMonth),
+		fmt.Sprintf("DELETE FROM %s WHERE %s", defaultPartition, inMonth),
+		fmt.Sprintf("CREATE TABLE %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+			pgx.Identifier{partition}.Sanitize(),
+			parent,
+			start.Format(time.RFC3339),
+			end.Format(time.RFC3339)),
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM moved", parent),
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("%s: %w", partition, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", partition, err)
+	}
+	return nil
+}
+
+// ensurePartitions creates the partitions for the current month and the
+// configured number of months ahead, once per month. Values falling outside
+// of the partitions are stored in the default partitions.
+func (processor *Processor) ensurePartitions(now time.Time) error {
+	if !processor.partitioned || now.Before(processor.nextPartitionCheck) {
+		return nil
+	}
+
+	err := processor.retryWrite(func() error {
+		return CreatePartitions(processor.conn, now, processor.Config.Sql.PartitionsAhead)
+	})
+	if err != nil {
+		processor.nextPartitionCheck = now.Add(PARTITION_RETRY_INTERVAL)
+		return err
+	}
+
+	now = now.UTC()
+	processor.nextPartitionCheck = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+	return nil
+}
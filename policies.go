@@ -0,0 +1,233 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// A PolicyConfig stores the retention and compression policies to apply to
+// the hypertables
+type PolicyConfig struct {
+	RawRetention    time.Duration // how long to keep high-rate raw data (current, power, voltage), 0 = forever
+	EnergyRetention time.Duration // how long to keep energy indexes, 0 = forever
+	CompressAfter   time.Duration // how old chunks have to be before being compressed, 0 = no compression
+}
+
+// A PolicyState is the current state of the policies of a hypertable
+type PolicyState struct {
+	Hypertable         string         // hypertable name
+	CompressionEnabled bool           // whether compression is enabled on the hypertable
+	DropAfter          sql.NullString // retention policy, if any
+	CompressAfter      sql.NullString // compression policy, if any
+}
+
+// A hypertablePolicy describes how to manage the policies of a hypertable
+type hypertablePolicy struct {
+	name      string        // hypertable name
+	segmentBy string        // columns to segment compressed data by
+	raw       bool          // whether the hypertable holds high-rate raw data
+	refresh   time.Duration // largest refresh window of the continuous aggregates reading the hypertable
+}
+
+// managedHypertables is the list of hypertables whose policies are managed
+var managedHypertables []hypertablePolicy = []hypertablePolicy{
+	{name: "current", segmentBy: "phase", raw: true, refresh: AGGREGATE_REFRESH_WINDOW},
+	{name: "power", segmentBy: "", raw: true, refresh: AGGREGATE_REFRESH_WINDOW},
+	{name: "voltage", segmentBy: "phase, kind", raw: true},
+	{name: "injected_power", segmentBy: "", raw: true},
+	{name: "energy", segmentBy: "tariff", raw: false, refresh: AGGREGATE_REFRESH_WINDOW},
+	{name: "injected_energy", segmentBy: "", raw: false},
+	{name: "reactive_energy", segmentBy: "quadrant", raw: false},
+}
+
+const (
+	// The monthly continuous aggregates refresh the last 80 days: the raw
+	// data they read has to be kept longer than that
+	AGGREGATE_REFRESH_WINDOW = 80 * 24 * time.Hour
+
+	// SQL Query to check if a policy is already in place with the expected interval
+	SelectPolicyQuery string = `
+	SELECT count(*) FROM timescaledb_information.jobs
+	WHERE proc_name = $1 AND hypertable_schema = current_schema() AND hypertable_name = $2
+	AND (config->>$3)::interval = $4::interval`
+
+	// SQL Query to check if compression is enabled on a hypertable
+	SelectCompressionEnabledQuery string = `
+	SELECT compression_enabled FROM timescaledb_information.hypertables
+	WHERE hypertable_schema = current_schema() AND hypertable_name = $1`
+
+	// SQL Query to list the policies of all hypertables
+	SelectPoliciesQuery string = `
+	SELECT h.hypertable_name, h.compression_enabled,
+	       (SELECT j.config->>'drop_after' FROM timescaledb_information.jobs j
+	        WHERE j.proc_name = 'policy_retention' AND j.hypertable_schema = h.hypertable_schema AND j.hypertable_name = h.hypertable_name),
+	       (SELECT j.config->>'compress_after' FROM timescaledb_information.jobs j
+	        WHERE j.proc_name = 'policy_compression' AND j.hypertable_schema = h.hypertable_schema AND j.hypertable_name = h.hypertable_name)
+	FROM timescaledb_information.hypertables h
+	WHERE h.hypertable_schema = current_schema()
+	ORDER BY h.hypertable_name`
+)
+
+// ApplyPolicies reconciles the retention and compression policies of the
+// hypertables with the configuration. Policies already in place with the
+// expected interval are left untouched. Policies are a TimescaleDB feature:
+// without it, an error is returned if any is configured. Retentions have to
+// exceed the refresh window of the continuous aggregates, otherwise their
+// refresh would erase buckets whose raw data has been dropped.
+func (m *Migrator) ApplyPolicies(config PolicyConfig) error {
+	for _, hypertable := range managedHypertables {
+		retention := hypertable.retention(config)
+		if retention != 0 && retention <= hypertable.refresh {
+			return fmt.Errorf("retention of %s for %s must exceed the refresh window of its continuous aggregates (%s)",
+				days(retention), hypertable.name, days(hypertable.refresh))
+		}
+	}
+
+	timescale, err := m.HasTimescaleDb()
+	if err != nil {
+		return err
+	}
+	if !timescale {
+		if config != (PolicyConfig{}) {
+			return fmt.Errorf("retention and compression policies require the TimescaleDB extension")
+		}
+		return nil
+	}
+
+	for _, hypertable := range managedHypertables {
+		if err := applyRetentionPolicy(m.db, hypertable, hypertable.retention(config)); err != nil {
+			return err
+		}
+		if err := applyCompressionPolicy(m.db, hypertable, config.CompressAfter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retention returns the configured retention of the hypertable
+func (hypertable hypertablePolicy) retention(config PolicyConfig) time.Duration {
+	if hypertable.raw {
+		return config.RawRetention
+	}
+	return config.EnergyRetention
+}
+
+// days formats a duration as a number of days
+func days(d time.Duration) string {
+	return strconv.FormatFloat(d.Hours()/24, 'f', -1, 64) + " days"
+}
+
+// hasPolicy returns true if the policy is in place with the expected interval
+func hasPolicy(db *sql.DB, proc, hypertable, key string, interval time.Duration) (bool, error) {
+	var count int
+	err := db.QueryRow(SelectPolicyQuery, proc, hypertable, key, interval).Scan(&count)
+	return count > 0, err
+}
+
+// applyRetentionPolicy reconciles the retention policy of a hypertable
+func applyRetentionPolicy(db *sql.DB, hypertable hypertablePolicy, retention time.Duration) error {
+	if retention == 0 {
+		_, err := db.Exec("SELECT remove_retention_policy($1, if_exists => true)", hypertable.name)
+		return err
+	}
+
+	ok, err := hasPolicy(db, "policy_retention", hypertable.name, "drop_after", retention)
+	if err != nil || ok {
+		return err
+	}
+
+	if _, err := db.Exec("SELECT remove_retention_policy($1, if_exists => true)", hypertable.name); err != nil {
+		return err
+	}
+	_, err = db.Exec("SELECT add_retention_policy($1, $2::interval)", hypertable.name, retention)
+	return err
+}
+
+// applyCompressionPolicy enables compression on a hypertable, if needed, and
+// reconciles its compression policy
+func applyCompressionPolicy(db *sql.DB, hypertable hypertablePolicy, after time.Duration) error {
+	if after == 0 {
+		_, err := db.Exec("SELECT remove_compression_policy($1, if_exists => true)", hypertable.name)
+		return err
+	}
+
+	var enabled bool
+	if err := db.QueryRow(SelectCompressionEnabledQuery, hypertable.name).Scan(&enabled); err != nil {
+		return err
+	}
+	if !enabled {
+		query := "ALTER TABLE " + hypertable.name + " SET (timescaledb.compress, timescaledb.compress_orderby = 'timestamp DESC'"
+		if hypertable.segmentBy != "" {
+			query += ", timescaledb.compress_segmentby = '" + hypertable.segmentBy + "'"
+		}
+		query += ")"
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	ok, err := hasPolicy(db, "policy_compression", hypertable.name, "compress_after", after)
+	if err != nil || ok {
+		return err
+	}
+
+	if _, err := db.Exec("SELECT remove_compression_policy($1, if_exists => true)", hypertable.name); err != nil {
+		return err
+	}
+	_, err = db.Exec("SELECT add_compression_policy($1, $2::interval)", hypertable.name, after)
+	return err
+}
+
+// GetPolicies returns the current state of the policies of all hypertables
+func (m *Migrator) GetPolicies() ([]PolicyState, error) {
+	timescale, err := m.HasTimescaleDb()
+	if err != nil {
+		return nil, err
+	}
+	if !timescale {
+		return nil, fmt.Errorf("retention and compression policies require the TimescaleDB extension")
+	}
+
+	rows, err := m.db.Query(SelectPoliciesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []PolicyState
+	for rows.Next() {
+		var policy PolicyState
+		err := rows.Scan(&policy.Hypertable, &policy.CompressionEnabled, &policy.DropAfter, &policy.CompressAfter)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, rows.Err()
+}
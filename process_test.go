@@ -0,0 +1,111 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnixEpochUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{`1600000000`, time.Unix(1600000000, 0)},
+		{`1600000000.5`, time.Unix(1600000000, 500*int64(time.Millisecond))},
+		{`1600000000.123456789123`, time.Unix(1600000000, 123456789)},
+		{`-1.5`, time.Unix(-2, 500*int64(time.Millisecond))},
+		{`-0.25`, time.Unix(-1, 750*int64(time.Millisecond))},
+		{`1600000000005`, time.Unix(1600000000, 5*int64(time.Millisecond))},
+		{`-1600000000005`, time.Unix(-1600000001, 995*int64(time.Millisecond))},
+		{`"1600000000"`, time.Unix(1600000000, 0)},
+		{`"2020-09-13T12:26:40Z"`, time.Unix(1600000000, 0)},
+		{`"2020-09-13T14:26:40.5+02:00"`, time.Unix(1600000000, 500*int64(time.Millisecond))},
+	}
+	for _, test := range tests {
+		var ts UnixEpoch
+		if err := ts.UnmarshalJSON([]byte(test.input)); err != nil {
+			t.Errorf("%s: %s", test.input, err)
+			continue
+		}
+		if !time.Time(ts).Equal(test.want) {
+			t.Errorf("%s: got %s, want %s", test.input, time.Time(ts).UTC(), test.want.UTC())
+		}
+	}
+}
+
+func TestUnixEpochUnmarshalJSONErrors(t *testing.T) {
+	for _, input := range []string{``, `"abc"`, `1.x`, `1.-5`, `"2020-09-13 12:26:40"`} {
+		var ts UnixEpoch
+		if err := ts.UnmarshalJSON([]byte(input)); err == nil {
+			t.Errorf("%s: expected an error, got %s", input, time.Time(ts).UTC())
+		}
+	}
+}
+
+func TestUnixEpochMarshalJSON(t *testing.T) {
+	tests := []struct {
+		input time.Time
+		want  string
+	}{
+		{time.Unix(1600000000, 0), `1600000000`},
+		{time.Unix(1600000000, 5*int64(time.Millisecond)), `1600000000.005`},
+		{time.Unix(1600000000, 123456789), `1600000000.123`},
+		{time.Unix(-2, 500*int64(time.Millisecond)), `-1.500`},
+		{time.Unix(-1, 0), `-1`},
+	}
+	for _, test := range tests {
+		b, err := UnixEpoch(test.input).MarshalJSON()
+		if err != nil {
+			t.Errorf("%s: %s", test.input.UTC(), err)
+			continue
+		}
+		if string(b) != test.want {
+			t.Errorf("%s: got %s, want %s", test.input.UTC(), b, test.want)
+		}
+	}
+}
+
+func TestUnixEpochRoundTrip(t *testing.T) {
+	for _, input := range []time.Time{
+		time.Unix(1600000000, 0),
+		time.Unix(1600000000, 5*int64(time.Millisecond)),
+		time.Unix(1600000000, 999*int64(time.Millisecond)),
+		time.Unix(-2, 500*int64(time.Millisecond)),
+		time.Unix(-1, 1*int64(time.Millisecond)),
+	} {
+		b, err := UnixEpoch(input).MarshalJSON()
+		if err != nil {
+			t.Errorf("%s: %s", input.UTC(), err)
+			continue
+		}
+		var ts UnixEpoch
+		if err := ts.UnmarshalJSON(b); err != nil {
+			t.Errorf("%s: %s", b, err)
+			continue
+		}
+		if !time.Time(ts).Equal(input) {
+			t.Errorf("%s: got %s back from %s", input.UTC(), time.Time(ts).UTC(), b)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A PrometheusConfig stores where to send the Prometheus remote-write
+// requests
+type PrometheusConfig struct {
+	Url         string        // URL of the remote-write endpoint
+	Username    string        // username for basic authentication (optional)
+	Password    string        // password for basic authentication (optional)
+	BearerToken string        // bearer token (optional)
+	Meter       string        // value of the meter label
+	Timeout     time.Duration // how much time to wait for the server to respond
+}
+
+// A PrometheusSink sends points to a Prometheus compatible remote-write
+// endpoint (Prometheus, Mimir, Cortex, VictoriaMetrics, ...)
+type PrometheusSink struct {
+	config PrometheusConfig // the configuration
+	client http.Client      // the HTTP client
+}
+
+// A promSeries is a time series of a remote-write request
+type promSeries struct {
+	labels  [][2]string  // label names and values, sorted by name
+	samples []promSample // samples, sorted by timestamp
+}
+
+// A promSample is a sample of a remote-write request
+type promSample struct {
+	value     float64 // the value
+	timestamp int64   // milliseconds since the Unix epoch
+}
+
+// NewPrometheusSink creates a sink sending remote-write requests to the
+// configured endpoint
+func NewPrometheusSink(config PrometheusConfig) (*PrometheusSink, error) {
+	if config.Url == "" {
+		return nil, fmt.Errorf("prometheus: an URL is required")
+	}
+
+	return &PrometheusSink{
+		config: config,
+		client: http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+// metricName returns the name of the metric holding a field of a table,
+// such as tic_power or tic_energy_reading
+func metricName(table, field string) string {
+	if table == field {
+		return "tic_" + table
+	}
+	return "tic_" + table + "_" + field
+}
+
+// series groups the points by time series
+func (sink *PrometheusSink) series(points []Point) []*promSeries {
+	index := make(map[string]*promSeries)
+	var series []*promSeries
+	for _, point := range points {
+		for field, value := range point.Fields {
+			labels := [][2]string{{"__name__", metricName(point.Table, field)}}
+			for k, v := range point.Tags {
+				labels = append(labels, [2]string{k, v})
+			}
+			if sink.config.Meter != "" {
+				labels = append(labels, [2]string{"meter", sink.config.Meter})
+			}
+			sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+
+			var key strings.Builder
+			for _, label := range labels {
+				fmt.Fprintf(&key, "%s=%q,", label[0], label[1])
+			}
+
+			s, ok := index[key.String()]
+			if !ok {
+				s = &promSeries{labels: labels}
+				index[key.String()] = s
+				series = append(series, s)
+			}
+
+			var f float64
+			switch v := value.(type) {
+			case int64:
+				f = float64(v)
+			case float64:
+				f = v
+			default:
+				continue
+			}
+			s.samples = append(s.samples, promSample{value: f, timestamp: point.Timestamp.UnixNano() / int64(time.Millisecond)})
+		}
+	}
+
+	for _, s := range series {
+		sort.SliceStable(s.samples, func(i, j int) bool { return s.samples[i].timestamp < s.samples[j].timestamp })
+	}
+
+	return series
+}
+
+// encodeWriteRequest encodes the time series as a prometheus.WriteRequest
+// protobuf message
+func encodeWriteRequest(series []*promSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, label := range s.labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label[0])
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+		for _, sample := range s.samples {
+			var smp []byte
+			smp = protowire.AppendTag(smp, 1, protowire.Fixed64Type)
+			smp = protowire.AppendFixed64(smp, math.Float64bits(sample.value))
+			smp = protowire.AppendTag(smp, 2, protowire.VarintType)
+			smp = protowire.AppendVarint(smp, uint64(sample.timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, smp)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// Write sends a batch of points in a single remote-write request
+func (sink *PrometheusSink) Write(points []Point) error {
+	payload := snappy.Encode(nil, encodeWriteRequest(sink.series(points)))
+
+	req, err := http.NewRequest("POST", sink.config.Url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if sink.config.Username != "" {
+		req.SetBasicAuth(sink.config.Username, sink.config.Password)
+	} else if sink.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+sink.config.BearerToken)
+	}
+
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("prometheus: unexpected status %s", resp.Status)
+		if body, _ := io.ReadAll(io.LimitReader(resp.Body, 512)); len(bytes.TrimSpace(body)) > 0 {
+			err = fmt.Errorf("%s: %s", err, bytes.TrimSpace(body))
+		}
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return TransientError{err}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Flush does nothing since points are sent by Write
+func (sink *PrometheusSink) Flush() error {
+	return nil
+}
+
+// Close does nothing since the sink holds no resources
+func (sink *PrometheusSink) Close() error {
+	return nil
+}
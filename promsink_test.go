@@ -0,0 +1,216 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A decodedSeries is a time series decoded from a remote-write request
+type decodedSeries struct {
+	labels  map[string]string
+	samples []promSample
+}
+
+// consumeMessage iterates over the fields of a protobuf message
+func consumeMessage(t *testing.T, b []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %s", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatalf("invalid bytes: %s", protowire.ParseError(n))
+			}
+			fn(num, typ, v, 0)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				t.Fatalf("invalid fixed64: %s", protowire.ParseError(n))
+			}
+			fn(num, typ, nil, v)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("invalid varint: %s", protowire.ParseError(n))
+			}
+			fn(num, typ, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+}
+
+// decodeWriteRequest decodes a snappy-compressed prometheus.WriteRequest
+func decodeWriteRequest(t *testing.T, body []byte) []decodedSeries {
+	t.Helper()
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("snappy: %s", err)
+	}
+
+	var series []decodedSeries
+	consumeMessage(t, raw, func(num protowire.Number, typ protowire.Type, ts []byte, _ uint64) {
+		if num != 1 {
+			t.Fatalf("unexpected WriteRequest field %d", num)
+		}
+		s := decodedSeries{labels: make(map[string]string)}
+		consumeMessage(t, ts, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				var name, value string
+				consumeMessage(t, v, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+				})
+				s.labels[name] = value
+			case 2:
+				var sample promSample
+				consumeMessage(t, v, func(num protowire.Number, typ protowire.Type, _ []byte, scalar uint64) {
+					if num == 1 {
+						sample.value = math.Float64frombits(scalar)
+					} else {
+						sample.timestamp = int64(scalar)
+					}
+				})
+				s.samples = append(s.samples, sample)
+			}
+		})
+		series = append(series, s)
+	})
+	return series
+}
+
+func TestPrometheusSinkWrite(t *testing.T) {
+	var series []decodedSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		if r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			t.Errorf("unexpected remote-write version: %q", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		series = decodeWriteRequest(t, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewPrometheusSink(PrometheusConfig{Url: server.URL, Meter: "home", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Unix(1600000000, 250*int64(time.Millisecond))
+	err = sink.Write([]Point{
+		{Table: "current", Timestamp: ts.Add(time.Second), Tags: map[string]string{"phase": "1"}, Fields: map[string]interface{}{"current": int64(12)}},
+		{Table: "current", Timestamp: ts, Tags: map[string]string{"phase": "1"}, Fields: map[string]interface{}{"current": int64(10)}},
+		{Table: "energy", Timestamp: ts, Tags: map[string]string{"tariff": "HCHP"}, Fields: map[string]interface{}{"reading": int64(123456789)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(series) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(series))
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].labels["__name__"] < series[j].labels["__name__"] })
+
+	current := series[0]
+	if current.labels["__name__"] != "tic_current" || current.labels["meter"] != "home" || current.labels["phase"] != "1" || len(current.labels) != 3 {
+		t.Errorf("unexpected current labels: %v", current.labels)
+	}
+	expected := []promSample{{10, 1600000000250}, {12, 1600000001250}}
+	if len(current.samples) != 2 || current.samples[0] != expected[0] || current.samples[1] != expected[1] {
+		t.Errorf("expected current samples %v, got %v", expected, current.samples)
+	}
+
+	energy := series[1]
+	if energy.labels["__name__"] != "tic_energy_reading" || energy.labels["meter"] != "home" || energy.labels["tariff"] != "HCHP" || len(energy.labels) != 3 {
+		t.Errorf("unexpected energy labels: %v", energy.labels)
+	}
+	if len(energy.samples) != 1 || energy.samples[0] != (promSample{123456789, 1600000000250}) {
+		t.Errorf("unexpected energy samples: %v", energy.samples)
+	}
+}
+
+func TestPrometheusSinkRetry(t *testing.T) {
+	for _, tc := range []struct {
+		status   int
+		requests int
+	}{
+		{http.StatusServiceUnavailable, 3},
+		{http.StatusBadRequest, 1},
+	} {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			http.Error(w, strings.ToLower(http.StatusText(tc.status)), tc.status)
+		}))
+
+		processor := NewProcessor(ProcessorConfig{
+			Retry:  RetryConfig{InitialInterval: time.Millisecond, WriteAttempts: 3},
+			Logger: log.New(io.Discard, "", 0),
+		})
+		sink, err := NewPrometheusSink(PrometheusConfig{Url: server.URL, Timeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		points := []Point{{Table: "power", Timestamp: time.Unix(1600000000, 0), Fields: map[string]interface{}{"power": int64(1200)}}}
+		err = processor.retry("prometheus", func() error { return sink.Write(points) })
+		server.Close()
+
+		if err == nil {
+			t.Errorf("status %d: expected an error", tc.status)
+		}
+		if requests != tc.requests {
+			t.Errorf("status %d: expected %d request(s), got %d", tc.status, tc.requests, requests)
+		}
+	}
+}
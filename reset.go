@@ -0,0 +1,216 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A MeterReset represents a decrease of an energy index, caused by a meter
+// replacement or a counter wrap
+type MeterReset struct {
+	Timestamp       UnixEpoch `json:"ts"`
+	Tariff          string    `json:"tariff"`
+	PreviousReading int64     `json:"previous_reading"`
+	Reading         int64     `json:"reading"`
+	Offset          int64     `json:"offset"` // value to add to the readings from now on to keep them monotonic
+}
+
+// A ResetConfig stores the settings of the meter reset detector
+type ResetConfig struct {
+	Confirmations int // how many consecutive lower readings confirm a meter reset
+}
+
+// An energyIndex is the last known state of an energy index
+type energyIndex struct {
+	reading        int64     // last reading
+	timestamp      time.Time // timestamp of the last reading
+	previous       int64     // reading before the last one, -1 if unknown
+	offset         int64     // offset of the last reset
+	lower          int       // number of consecutive readings lower than the last one
+	lowerReading   int64     // first of those lower readings
+	lowerTimestamp time.Time // timestamp of the first lower reading
+}
+
+const (
+	// Alert type sent when an energy index decreases
+	ALERT_METER_RESET = "meter_reset"
+
+	// SQL Query to store a meter reset
+	InsertMeterResetQuery string = `
+	INSERT INTO meter_reset VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (timestamp, tariff) DO NOTHING`
+
+	// SQL Query to retrieve the two last readings of a tariff
+	SelectLastEnergyReadingsQuery string = `
+	SELECT timestamp, reading FROM energy WHERE tariff = $1 ORDER BY timestamp DESC LIMIT 2`
+
+	// SQL Query to retrieve the offset of the last meter reset
+	SelectLastMeterResetQuery string = `
+	SELECT reading_offset FROM meter_reset WHERE tariff = $1 ORDER BY timestamp DESC LIMIT 1`
+)
+
+// checkResetConfig returns an error if the meter reset settings are invalid
+func (processor *Processor) checkResetConfig() error {
+	if processor.Config.Reset.Confirmations < 1 {
+		return fmt.Errorf("invalid meter reset confirmations: %d", processor.Config.Reset.Confirmations)
+	}
+	return nil
+}
+
+// loadEnergyIndex retrieves the two last readings and the offset of a tariff
+// from the database
+func (processor *Processor) loadEnergyIndex(tariff string) (*energyIndex, error) {
+	index := &energyIndex{reading: -1, previous: -1}
+	err := processor.retryWrite(func() error {
+		rows, err := processor.conn.Query(SelectLastEnergyReadingsQuery, tariff)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		index.reading, index.previous = -1, -1
+		for rows.Next() {
+			var ts time.Time
+			var reading int64
+			if err := rows.Scan(&ts, &reading); err != nil {
+				return err
+			}
+			if index.reading < 0 {
+				index.reading, index.timestamp = reading, ts
+			} else {
+				index.previous = reading
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = processor.retryWrite(func() error {
+		return processor.conn.QueryRow(SelectLastMeterResetQuery, tariff).Scan(&index.offset)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// getEnergyIndex returns the last known state of a tariff, loading it from
+// the database on first use
+func (processor *Processor) getEnergyIndex(tariff string) (*energyIndex, error) {
+	index, ok := processor.energyIndexes[tariff]
+	if !ok {
+		var err error
+		index, err = processor.loadEnergyIndex(tariff)
+		if err != nil {
+			return nil, err
+		}
+		processor.energyIndexes[tariff] = index
+	}
+	return index, nil
+}
+
+// pendingDecrease returns true if the reading is lower than the last one, is
+// not the end of a spike and still has to be confirmed by further readings
+func (index *energyIndex) pendingDecrease(value int64, ts time.Time, confirmations int) bool {
+	if index.reading < 0 || ts.Before(index.timestamp) || value >= index.reading {
+		return false
+	}
+	if index.previous >= 0 && value >= index.previous {
+		return false
+	}
+	return index.lower+1 < confirmations
+}
+
+// countLower counts a reading lower than the last one
+func (index *energyIndex) countLower(value int64, ts time.Time) {
+	index.lower++
+	if index.lower == 1 {
+		index.lowerReading, index.lowerTimestamp = value, ts
+	}
+}
+
+// detectMeterReset records a meter reset once enough consecutive readings of
+// a tariff confirmed that its index decreased. A reading back above the one
+// before the last means the last one was an isolated spike: no reset is
+// recorded. Late readings are ignored.
+func (processor *Processor) detectMeterReset(msg TicMessage, value int64) error {
+	index, err := processor.getEnergyIndex(msg.Field)
+	if err != nil {
+		return err
+	}
+
+	ts := time.Time(msg.Timestamp)
+	if index.reading >= 0 && ts.Before(index.timestamp) {
+		return nil
+	}
+
+	if index.reading < 0 || value >= index.reading {
+		index.lower = 0
+		index.previous, index.reading, index.timestamp = index.reading, value, ts
+		return nil
+	}
+
+	if index.previous >= 0 && value >= index.previous {
+		processor.Config.Logger.Printf("Energy index %s: ignoring a spike to %d between %d and %d", msg.Field, index.reading, index.previous, value)
+		index.lower = 0
+		index.reading, index.timestamp = value, ts
+		return nil
+	}
+
+	// keep the last reading until the decrease is confirmed
+	index.countLower(value, ts)
+	if index.lower < processor.Config.Reset.Confirmations {
+		return nil
+	}
+
+	reset := MeterReset{
+		Timestamp:       UnixEpoch(index.lowerTimestamp),
+		Tariff:          msg.Field,
+		PreviousReading: index.reading,
+		Reading:         index.lowerReading,
+		Offset:          index.offset + index.reading - index.lowerReading,
+	}
+	processor.exec(InsertMeterResetQuery,
+		index.lowerTimestamp,
+		reset.Tariff,
+		reset.PreviousReading,
+		reset.Reading,
+		reset.Offset)
+
+	processor.Config.Logger.Printf("Energy index %s went from %d to %d, offset is now %d", reset.Tariff, reset.PreviousReading, reset.Reading, reset.Offset)
+	processor.sendAlert(Alert{
+		Type:      ALERT_METER_RESET,
+		Timestamp: reset.Timestamp,
+		Data:      reset,
+	})
+
+	index.offset = reset.Offset
+	index.lower = 0
+	index.previous, index.reading, index.timestamp = index.lowerReading, value, ts
+	return nil
+}
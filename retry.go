@@ -0,0 +1,142 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A RetryConfig stores how to retry failed operations
+type RetryConfig struct {
+	InitialInterval time.Duration // how much time to wait after the first failure
+	MaxInterval     time.Duration // upper bound of the exponential backoff
+	MaxAttempts     int           // how many times to try connecting at startup (0 = forever)
+	WriteAttempts   int           // how many times to try a database write hitting a transient error
+}
+
+// nextInterval doubles the wait interval, up to the configured maximum
+func (config RetryConfig) nextInterval(interval time.Duration) time.Duration {
+	interval *= 2
+	if config.MaxInterval > 0 && interval > config.MaxInterval {
+		interval = config.MaxInterval
+	}
+	return interval
+}
+
+// retryStartup calls fn until it succeeds or the maximum number of attempts
+// is reached, waiting longer and longer between attempts.
+func (processor *Processor) retryStartup(what string, fn func() error) error {
+	config := processor.Config.Retry
+	interval := config.InitialInterval
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if config.MaxAttempts > 0 && attempt >= config.MaxAttempts {
+			return err
+		}
+
+		processor.Config.Logger.Printf("%s: %s (attempt %d), retrying in %s...", what, err, attempt, interval)
+		time.Sleep(interval)
+		interval = config.nextInterval(interval)
+	}
+}
+
+// retryWrite calls fn until it succeeds, fails with a permanent error or the
+// maximum number of write attempts is reached.
+func (processor *Processor) retryWrite(fn func() error) error {
+	return processor.retry("sql", fn)
+}
+
+// retry calls fn until it succeeds, fails with a permanent error or the
+// maximum number of write attempts is reached.
+func (processor *Processor) retry(what string, fn func() error) error {
+	config := processor.Config.Retry
+	interval := config.InitialInterval
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsTransientError(err) || attempt >= config.WriteAttempts {
+			return err
+		}
+
+		processor.Config.Logger.Printf("%s: %s (attempt %d), retrying in %s...", what, err, attempt, interval)
+		time.Sleep(interval)
+		interval = config.nextInterval(interval)
+	}
+}
+
+// A TransientError wraps an error that the caller knows to be temporary,
+// such as an HTTP 5xx response
+type TransientError struct {
+	Err error
+}
+
+func (e TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e TransientError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransientError returns true if the error is likely to disappear when the
+// operation is retried: serialization failures, deadlocks, server shutting
+// down or starting up, too many connections, network errors and errors
+// wrapped in a TransientError.
+func IsTransientError(err error) bool {
+	var transientErr TransientError
+	if errors.As(err, &transientErr) {
+		return true
+	}
+
+	var sqlErr interface{ SQLState() string }
+	if errors.As(err, &sqlErr) {
+		code := sqlErr.SQLState()
+		switch {
+		case strings.HasPrefix(code, "08"): // connection exception
+			return true
+		case code == "40001", code == "40P01": // serialization failure, deadlock
+			return true
+		case code == "53300": // too many connections
+			return true
+		case code == "57P01", code == "57P02", code == "57P03": // shutdown, cannot connect now
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &netErr)
+}
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE overload (
+   start_time  TIMESTAMP (0) WITHOUT TIME ZONE NOT NULL,
+   end_time    TIMESTAMP (0) WITHOUT TIME ZONE,
+   phase       INTEGER NOT NULL DEFAULT(0),
+   peak        INTEGER NOT NULL,
+   PRIMARY KEY (start_time, phase)
+);
+
+-- +goose Down
+DROP TABLE overload;
@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE meter_status (
+   timestamp           TIMESTAMP (0) WITHOUT TIME ZONE NOT NULL PRIMARY KEY,
+   raw                 BIGINT NOT NULL,
+   contact_open        BOOLEAN NOT NULL,
+   cutoff_state        INTEGER NOT NULL,
+   cover_open          BOOLEAN NOT NULL,
+   overvoltage         BOOLEAN NOT NULL,
+   overpower           BOOLEAN NOT NULL,
+   producer            BOOLEAN NOT NULL,
+   negative_energy     BOOLEAN NOT NULL,
+   supplier_tariff     INTEGER NOT NULL,
+   distributor_tariff  INTEGER NOT NULL,
+   clock_degraded      BOOLEAN NOT NULL,
+   tic_standard        BOOLEAN NOT NULL,
+   euridis_state       INTEGER NOT NULL,
+   cpl_status          INTEGER NOT NULL,
+   cpl_synchronized    BOOLEAN NOT NULL,
+   tempo_today         INTEGER NOT NULL,
+   tempo_tomorrow      INTEGER NOT NULL,
+   mobile_peak_notice  INTEGER NOT NULL,
+   mobile_peak         INTEGER NOT NULL
+);
+
+-- +goose Down
+DROP TABLE meter_status;
@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE injected_energy (
+   timestamp   TIMESTAMP (0) WITHOUT TIME ZONE UNIQUE NOT NULL,
+   reading     INTEGER NOT NULL
+);
+
+CREATE TABLE injected_power (
+   timestamp   TIMESTAMP (0) WITHOUT TIME ZONE UNIQUE NOT NULL,
+   power       INTEGER NOT NULL
+);
+
+CREATE TABLE reactive_energy (
+   timestamp   TIMESTAMP (0) WITHOUT TIME ZONE NOT NULL,
+   quadrant    INTEGER NOT NULL,
+   reading     INTEGER NOT NULL,
+   UNIQUE (timestamp, quadrant)
+);
+
+SELECT create_hypertable('injected_energy','timestamp');
+SELECT create_hypertable('injected_power','timestamp');
+SELECT create_hypertable('reactive_energy','timestamp');
+
+CREATE VIEW net_consumption AS
+WITH drawn AS (
+   SELECT bucket, sum(consumed) AS consumed
+   FROM (
+      SELECT time_bucket('1 hour', timestamp) AS bucket, max(reading) - min(reading) AS consumed
+      FROM energy
+      GROUP BY bucket, tariff
+   ) AS per_tariff
+   GROUP BY bucket
+), injected AS (
+   SELECT time_bucket('1 hour', timestamp) AS bucket, max(reading) - min(reading) AS injected
+   FROM injected_energy
+   GROUP BY bucket
+)
+SELECT coalesce(drawn.bucket, injected.bucket) AS bucket,
+       coalesce(drawn.consumed, 0) AS consumed,
+       coalesce(injected.injected, 0) AS injected,
+       coalesce(drawn.consumed, 0) - coalesce(injected.injected, 0) AS net
+FROM drawn FULL OUTER JOIN injected ON drawn.bucket = injected.bucket;
+
+-- +goose Down
+DROP VIEW net_consumption;
+DROP TABLE injected_energy;
+DROP TABLE injected_power;
+DROP TABLE reactive_energy;
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE voltage (
+   timestamp   TIMESTAMP (0) WITHOUT TIME ZONE NOT NULL,
+   phase       INTEGER NOT NULL,
+   kind        TEXT NOT NULL,
+   voltage     INTEGER NOT NULL,
+   UNIQUE (timestamp, phase, kind)
+);
+
+SELECT create_hypertable('voltage','timestamp');
+
+CREATE TABLE voltage_event (
+   start_time  TIMESTAMP (0) WITHOUT TIME ZONE NOT NULL,
+   end_time    TIMESTAMP (0) WITHOUT TIME ZONE,
+   phase       INTEGER NOT NULL,
+   kind        TEXT NOT NULL,
+   extreme     INTEGER NOT NULL,
+   PRIMARY KEY (start_time, phase)
+);
+
+-- +goose Down
+DROP TABLE voltage;
+DROP TABLE voltage_event;
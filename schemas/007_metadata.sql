@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE meter_metadata (
+   timestamp   TIMESTAMP (0) WITHOUT TIME ZONE NOT NULL,
+   label       TEXT NOT NULL,
+   value       TEXT NOT NULL,
+   PRIMARY KEY (timestamp, label)
+);
+
+CREATE TABLE tariff_profile (
+   timestamp    TIMESTAMP (0) WITHOUT TIME ZONE NOT NULL,
+   switchover   TIME (0) WITHOUT TIME ZONE NOT NULL,
+   tariff_index INTEGER NOT NULL,
+   action       INTEGER NOT NULL,
+   PRIMARY KEY (timestamp, switchover)
+);
+
+-- +goose Down
+DROP TABLE meter_metadata;
+DROP TABLE tariff_profile;
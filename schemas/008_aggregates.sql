@@ -0,0 +1,182 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+CREATE MATERIALIZED VIEW energy_hourly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', timestamp) AS bucket,
+       tariff,
+       first(reading, timestamp) AS first_reading,
+       last(reading, timestamp) AS last_reading,
+       min(reading) AS min_reading,
+       max(reading) AS max_reading
+FROM energy
+GROUP BY bucket, tariff
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW power_hourly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', timestamp) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW current_hourly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', timestamp) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase
+WITH NO DATA;
+
+SELECT add_continuous_aggregate_policy('energy_hourly_cagg', start_offset => INTERVAL '3 hours', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes');
+SELECT add_continuous_aggregate_policy('power_hourly_cagg', start_offset => INTERVAL '3 hours', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes');
+SELECT add_continuous_aggregate_policy('current_hourly_cagg', start_offset => INTERVAL '3 hours', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes');
+
+-- When the index decreased within the bucket (meter replacement or counter
+-- wrap), count what was consumed before and after the reset.
+CREATE VIEW energy_hourly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM energy_hourly_cagg;
+
+CREATE VIEW power_hourly AS
+SELECT bucket, avg_power, max_power
+FROM power_hourly_cagg;
+
+CREATE VIEW current_hourly AS
+SELECT bucket, phase, avg_current, max_current
+FROM current_hourly_cagg;
+
+CREATE MATERIALIZED VIEW energy_daily_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', timestamp) AS bucket,
+       tariff,
+       first(reading, timestamp) AS first_reading,
+       last(reading, timestamp) AS last_reading,
+       min(reading) AS min_reading,
+       max(reading) AS max_reading
+FROM energy
+GROUP BY bucket, tariff
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW power_daily_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', timestamp) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW current_daily_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', timestamp) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase
+WITH NO DATA;
+
+SELECT add_continuous_aggregate_policy('energy_daily_cagg', start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 hour');
+SELECT add_continuous_aggregate_policy('power_daily_cagg', start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 hour');
+SELECT add_continuous_aggregate_policy('current_daily_cagg', start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 hour');
+
+-- When the index decreased within the bucket (meter replacement or counter
+-- wrap), count what was consumed before and after the reset.
+CREATE VIEW energy_daily AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM energy_daily_cagg;
+
+CREATE VIEW power_daily AS
+SELECT bucket, avg_power, max_power
+FROM power_daily_cagg;
+
+CREATE VIEW current_daily AS
+SELECT bucket, phase, avg_current, max_current
+FROM current_daily_cagg;
+
+CREATE MATERIALIZED VIEW energy_monthly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 month', timestamp) AS bucket,
+       tariff,
+       first(reading, timestamp) AS first_reading,
+       last(reading, timestamp) AS last_reading,
+       min(reading) AS min_reading,
+       max(reading) AS max_reading
+FROM energy
+GROUP BY bucket, tariff
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW power_monthly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 month', timestamp) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW current_monthly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 month', timestamp) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase
+WITH NO DATA;
+
+SELECT add_continuous_aggregate_policy('energy_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('power_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('current_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+
+-- When the index decreased within the bucket (meter replacement or counter
+-- wrap), count what was consumed before and after the reset.
+CREATE VIEW energy_monthly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM energy_monthly_cagg;
+
+CREATE VIEW power_monthly AS
+SELECT bucket, avg_power, max_power
+FROM power_monthly_cagg;
+
+CREATE VIEW current_monthly AS
+SELECT bucket, phase, avg_current, max_current
+FROM current_monthly_cagg;
+
+-- +goose Down
+DROP VIEW energy_hourly;
+DROP VIEW power_hourly;
+DROP VIEW current_hourly;
+DROP MATERIALIZED VIEW energy_hourly_cagg;
+DROP MATERIALIZED VIEW power_hourly_cagg;
+DROP MATERIALIZED VIEW current_hourly_cagg;
+
+DROP VIEW energy_daily;
+DROP VIEW power_daily;
+DROP VIEW current_daily;
+DROP MATERIALIZED VIEW energy_daily_cagg;
+DROP MATERIALIZED VIEW power_daily_cagg;
+DROP MATERIALIZED VIEW current_daily_cagg;
+
+DROP VIEW energy_monthly;
+DROP VIEW power_monthly;
+DROP VIEW current_monthly;
+DROP MATERIALIZED VIEW energy_monthly_cagg;
+DROP MATERIALIZED VIEW power_monthly_cagg;
+DROP MATERIALIZED VIEW current_monthly_cagg;
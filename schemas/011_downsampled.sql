@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE downsampled (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   label       TEXT NOT NULL,
+   min         INTEGER NOT NULL,
+   avg         DOUBLE PRECISION NOT NULL,
+   max         INTEGER NOT NULL,
+   last        INTEGER NOT NULL,
+   samples     INTEGER NOT NULL,
+   UNIQUE (timestamp, label)
+);
+
+SELECT create_hypertable('downsampled','timestamp');
+
+-- +goose Down
+DROP TABLE downsampled;
@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE meter_reset (
+   timestamp         TIMESTAMPTZ (3) NOT NULL,
+   tariff            TEXT NOT NULL,
+   previous_reading  INTEGER NOT NULL,
+   reading           INTEGER NOT NULL,
+   reading_offset    BIGINT NOT NULL,
+   PRIMARY KEY (timestamp, tariff)
+);
+
+-- The monotonic reading is the reading plus the offset of the last reset
+-- that happened before it, so that it keeps increasing across meter
+-- replacements and counter wraps.
+CREATE VIEW energy_monotonic AS
+SELECT e.timestamp,
+       e.tariff,
+       e.reading,
+       e.reading + coalesce((SELECT r.reading_offset FROM meter_reset r
+                             WHERE r.tariff = e.tariff AND r.timestamp <= e.timestamp
+                             ORDER BY r.timestamp DESC LIMIT 1), 0) AS monotonic_reading
+FROM energy e;
+
+-- +goose Down
+DROP VIEW energy_monotonic;
+DROP TABLE meter_reset;
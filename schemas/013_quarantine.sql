@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE quarantine (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   received    TIMESTAMPTZ (3),
+   label       TEXT NOT NULL,
+   value       TEXT NOT NULL,
+   reason      TEXT NOT NULL
+);
+
+CREATE INDEX ON quarantine (timestamp);
+
+-- +goose Down
+DROP TABLE quarantine;
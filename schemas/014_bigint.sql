@@ -0,0 +1,569 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- Standard-mode indexes and long-running meters can exceed 2^31 Wh.
+--
+-- Continuous aggregates cannot survive a change of column type: they are
+-- recreated and rebuilt from the raw data still available. Every step can be
+-- run again if the migration is interrupted.
+
+-- Compression has to be disabled to change the type of a column. It is
+-- enabled again by the retention and compression policies at next startup.
+-- +goose StatementBegin
+DO $$
+DECLARE
+   h RECORD;
+BEGIN
+   FOR h IN SELECT hypertable_name FROM timescaledb_information.hypertables
+            WHERE hypertable_schema = current_schema() AND compression_enabled LOOP
+      PERFORM remove_compression_policy(h.hypertable_name::regclass, if_exists => true);
+      PERFORM decompress_chunk(c, true) FROM show_chunks(h.hypertable_name::regclass) c;
+      EXECUTE format('ALTER TABLE %I SET (timescaledb.compress = false)', h.hypertable_name);
+   END LOOP;
+END
+$$;
+-- +goose StatementEnd
+
+DROP VIEW IF EXISTS net_consumption;
+DROP VIEW IF EXISTS energy_monotonic;
+DROP VIEW IF EXISTS energy_hourly;
+DROP VIEW IF EXISTS power_hourly;
+DROP VIEW IF EXISTS current_hourly;
+DROP MATERIALIZED VIEW IF EXISTS energy_hourly_cagg;
+DROP MATERIALIZED VIEW IF EXISTS power_hourly_cagg;
+DROP MATERIALIZED VIEW IF EXISTS current_hourly_cagg;
+
+DROP VIEW IF EXISTS energy_daily;
+DROP VIEW IF EXISTS power_daily;
+DROP VIEW IF EXISTS current_daily;
+DROP MATERIALIZED VIEW IF EXISTS energy_daily_cagg;
+DROP MATERIALIZED VIEW IF EXISTS power_daily_cagg;
+DROP MATERIALIZED VIEW IF EXISTS current_daily_cagg;
+
+DROP VIEW IF EXISTS energy_monthly;
+DROP VIEW IF EXISTS power_monthly;
+DROP VIEW IF EXISTS current_monthly;
+DROP MATERIALIZED VIEW IF EXISTS energy_monthly_cagg;
+DROP MATERIALIZED VIEW IF EXISTS power_monthly_cagg;
+DROP MATERIALIZED VIEW IF EXISTS current_monthly_cagg;
+
+ALTER TABLE current ALTER COLUMN current TYPE BIGINT;
+ALTER TABLE power ALTER COLUMN power TYPE BIGINT;
+ALTER TABLE energy ALTER COLUMN reading TYPE BIGINT;
+ALTER TABLE injected_energy ALTER COLUMN reading TYPE BIGINT;
+ALTER TABLE injected_power ALTER COLUMN power TYPE BIGINT;
+ALTER TABLE reactive_energy ALTER COLUMN reading TYPE BIGINT;
+ALTER TABLE voltage ALTER COLUMN voltage TYPE BIGINT;
+ALTER TABLE overload ALTER COLUMN peak TYPE BIGINT;
+ALTER TABLE voltage_event ALTER COLUMN extreme TYPE BIGINT;
+ALTER TABLE downsampled ALTER COLUMN min TYPE BIGINT;
+ALTER TABLE downsampled ALTER COLUMN max TYPE BIGINT;
+ALTER TABLE downsampled ALTER COLUMN last TYPE BIGINT;
+ALTER TABLE meter_reset ALTER COLUMN previous_reading TYPE BIGINT;
+ALTER TABLE meter_reset ALTER COLUMN reading TYPE BIGINT;
+
+CREATE VIEW net_consumption AS
+WITH drawn AS (
+   SELECT bucket, sum(consumed) AS consumed
+   FROM (
+      SELECT time_bucket('1 hour', timestamp) AS bucket, max(reading) - min(reading) AS consumed
+      FROM energy
+      GROUP BY bucket, tariff
+   ) AS per_tariff
+   GROUP BY bucket
+), injected AS (
+   SELECT time_bucket('1 hour', timestamp) AS bucket, max(reading) - min(reading) AS injected
+   FROM injected_energy
+   GROUP BY bucket
+)
+SELECT coalesce(drawn.bucket, injected.bucket) AS bucket,
+       coalesce(drawn.consumed, 0) AS consumed,
+       coalesce(injected.injected, 0) AS injected,
+       coalesce(drawn.consumed, 0) - coalesce(injected.injected, 0) AS net
+FROM drawn FULL OUTER JOIN injected ON drawn.bucket = injected.bucket;
+
+CREATE VIEW energy_monotonic AS
+SELECT e.timestamp,
+       e.tariff,
+       e.reading,
+       e.reading + coalesce((SELECT r.reading_offset FROM meter_reset r
+                             WHERE r.tariff = e.tariff AND r.timestamp <= e.timestamp
+                             ORDER BY r.timestamp DESC LIMIT 1), 0) AS monotonic_reading
+FROM energy e;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+   tz TEXT := current_setting('tic.timezone');
+BEGIN
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW energy_hourly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', timestamp, %L) AS bucket,
+       tariff,
+       first(reading, timestamp) AS first_reading,
+       last(reading, timestamp) AS last_reading,
+       min(reading) AS min_reading,
+       max(reading) AS max_reading
+FROM energy
+GROUP BY bucket, tariff
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW power_hourly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', timestamp, %L) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW current_hourly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', timestamp, %L) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase
+WITH NO DATA$view$, tz);
+END
+$$;
+-- +goose StatementEnd
+
+SELECT add_continuous_aggregate_policy('energy_hourly_cagg', start_offset => INTERVAL '3 hours', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes');
+SELECT add_continuous_aggregate_policy('power_hourly_cagg', start_offset => INTERVAL '3 hours', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes');
+SELECT add_continuous_aggregate_policy('current_hourly_cagg', start_offset => INTERVAL '3 hours', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes');
+
+CREATE VIEW energy_hourly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM energy_hourly_cagg;
+
+CREATE VIEW power_hourly AS
+SELECT bucket, avg_power, max_power
+FROM power_hourly_cagg;
+
+CREATE VIEW current_hourly AS
+SELECT bucket, phase, avg_current, max_current
+FROM current_hourly_cagg;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+   tz TEXT := current_setting('tic.timezone');
+BEGIN
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW energy_daily_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', timestamp, %L) AS bucket,
+       tariff,
+       first(reading, timestamp) AS first_reading,
+       last(reading, timestamp) AS last_reading,
+       min(reading) AS min_reading,
+       max(reading) AS max_reading
+FROM energy
+GROUP BY bucket, tariff
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW power_daily_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', timestamp, %L) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW current_daily_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', timestamp, %L) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase
+WITH NO DATA$view$, tz);
+END
+$$;
+-- +goose StatementEnd
+
+SELECT add_continuous_aggregate_policy('energy_daily_cagg', start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 hour');
+SELECT add_continuous_aggregate_policy('power_daily_cagg', start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 hour');
+SELECT add_continuous_aggregate_policy('current_daily_cagg', start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 hour');
+
+CREATE VIEW energy_daily AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM energy_daily_cagg;
+
+CREATE VIEW power_daily AS
+SELECT bucket, avg_power, max_power
+FROM power_daily_cagg;
+
+CREATE VIEW current_daily AS
+SELECT bucket, phase, avg_current, max_current
+FROM current_daily_cagg;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+   tz TEXT := current_setting('tic.timezone');
+BEGIN
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW energy_monthly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 month', timestamp, %L) AS bucket,
+       tariff,
+       first(reading, timestamp) AS first_reading,
+       last(reading, timestamp) AS last_reading,
+       min(reading) AS min_reading,
+       max(reading) AS max_reading
+FROM energy
+GROUP BY bucket, tariff
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW power_monthly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 month', timestamp, %L) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW current_monthly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 month', timestamp, %L) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase
+WITH NO DATA$view$, tz);
+END
+$$;
+-- +goose StatementEnd
+
+SELECT add_continuous_aggregate_policy('energy_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('power_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('current_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+
+CREATE VIEW energy_monthly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM energy_monthly_cagg;
+
+CREATE VIEW power_monthly AS
+SELECT bucket, avg_power, max_power
+FROM power_monthly_cagg;
+
+CREATE VIEW current_monthly AS
+SELECT bucket, phase, avg_current, max_current
+FROM current_monthly_cagg;
+
+CALL refresh_continuous_aggregate('energy_hourly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('power_hourly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('current_hourly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('energy_daily_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('power_daily_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('current_daily_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('energy_monthly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('power_monthly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('current_monthly_cagg', NULL, NULL);
+
+-- +goose Down
+-- Fails if a value exceeds 2^31.
+-- Compression has to be disabled to change the type of a column. It is
+-- enabled again by the retention and compression policies at next startup.
+-- +goose StatementBegin
+DO $$
+DECLARE
+   h RECORD;
+BEGIN
+   FOR h IN SELECT hypertable_name FROM timescaledb_information.hypertables
+            WHERE hypertable_schema = current_schema() AND compression_enabled LOOP
+      PERFORM remove_compression_policy(h.hypertable_name::regclass, if_exists => true);
+      PERFORM decompress_chunk(c, true) FROM show_chunks(h.hypertable_name::regclass) c;
+      EXECUTE format('ALTER TABLE %I SET (timescaledb.compress = false)', h.hypertable_name);
+   END LOOP;
+END
+$$;
+-- +goose StatementEnd
+
+DROP VIEW IF EXISTS net_consumption;
+DROP VIEW IF EXISTS energy_monotonic;
+DROP VIEW IF EXISTS energy_hourly;
+DROP VIEW IF EXISTS power_hourly;
+DROP VIEW IF EXISTS current_hourly;
+DROP MATERIALIZED VIEW IF EXISTS energy_hourly_cagg;
+DROP MATERIALIZED VIEW IF EXISTS power_hourly_cagg;
+DROP MATERIALIZED VIEW IF EXISTS current_hourly_cagg;
+
+DROP VIEW IF EXISTS energy_daily;
+DROP VIEW IF EXISTS power_daily;
+DROP VIEW IF EXISTS current_daily;
+DROP MATERIALIZED VIEW IF EXISTS energy_daily_cagg;
+DROP MATERIALIZED VIEW IF EXISTS power_daily_cagg;
+DROP MATERIALIZED VIEW IF EXISTS current_daily_cagg;
+
+DROP VIEW IF EXISTS energy_monthly;
+DROP VIEW IF EXISTS power_monthly;
+DROP VIEW IF EXISTS current_monthly;
+DROP MATERIALIZED VIEW IF EXISTS energy_monthly_cagg;
+DROP MATERIALIZED VIEW IF EXISTS power_monthly_cagg;
+DROP MATERIALIZED VIEW IF EXISTS current_monthly_cagg;
+
+ALTER TABLE current ALTER COLUMN current TYPE INTEGER;
+ALTER TABLE power ALTER COLUMN power TYPE INTEGER;
+ALTER TABLE energy ALTER COLUMN reading TYPE INTEGER;
+ALTER TABLE injected_energy ALTER COLUMN reading TYPE INTEGER;
+ALTER TABLE injected_power ALTER COLUMN power TYPE INTEGER;
+ALTER TABLE reactive_energy ALTER COLUMN reading TYPE INTEGER;
+ALTER TABLE voltage ALTER COLUMN voltage TYPE INTEGER;
+ALTER TABLE overload ALTER COLUMN peak TYPE INTEGER;
+ALTER TABLE voltage_event ALTER COLUMN extreme TYPE INTEGER;
+ALTER TABLE downsampled ALTER COLUMN min TYPE INTEGER;
+ALTER TABLE downsampled ALTER COLUMN max TYPE INTEGER;
+ALTER TABLE downsampled ALTER COLUMN last TYPE INTEGER;
+ALTER TABLE meter_reset ALTER COLUMN previous_reading TYPE INTEGER;
+ALTER TABLE meter_reset ALTER COLUMN reading TYPE INTEGER;
+
+CREATE VIEW net_consumption AS
+WITH drawn AS (
+   SELECT bucket, sum(consumed) AS consumed
+   FROM (
+      SELECT time_bucket('1 hour', timestamp) AS bucket, max(reading) - min(reading) AS consumed
+      FROM energy
+      GROUP BY bucket, tariff
+   ) AS per_tariff
+   GROUP BY bucket
+), injected AS (
+   SELECT time_bucket('1 hour', timestamp) AS bucket, max(reading) - min(reading) AS injected
+   FROM injected_energy
+   GROUP BY bucket
+)
+SELECT coalesce(drawn.bucket, injected.bucket) AS bucket,
+       coalesce(drawn.consumed, 0) AS consumed,
+       coalesce(injected.injected, 0) AS injected,
+       coalesce(drawn.consumed, 0) - coalesce(injected.injected, 0) AS net
+FROM drawn FULL OUTER JOIN injected ON drawn.bucket = injected.bucket;
+
+CREATE VIEW energy_monotonic AS
+SELECT e.timestamp,
+       e.tariff,
+       e.reading,
+       e.reading + coalesce((SELECT r.reading_offset FROM meter_reset r
+                             WHERE r.tariff = e.tariff AND r.timestamp <= e.timestamp
+                             ORDER BY r.timestamp DESC LIMIT 1), 0) AS monotonic_reading
+FROM energy e;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+   tz TEXT := current_setting('tic.timezone');
+BEGIN
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW energy_hourly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', timestamp, %L) AS bucket,
+       tariff,
+       first(reading, timestamp) AS first_reading,
+       last(reading, timestamp) AS last_reading,
+       min(reading) AS min_reading,
+       max(reading) AS max_reading
+FROM energy
+GROUP BY bucket, tariff
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW power_hourly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', timestamp, %L) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW current_hourly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', timestamp, %L) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase
+WITH NO DATA$view$, tz);
+END
+$$;
+-- +goose StatementEnd
+
+SELECT add_continuous_aggregate_policy('energy_hourly_cagg', start_offset => INTERVAL '3 hours', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes');
+SELECT add_continuous_aggregate_policy('power_hourly_cagg', start_offset => INTERVAL '3 hours', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes');
+SELECT add_continuous_aggregate_policy('current_hourly_cagg', start_offset => INTERVAL '3 hours', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes');
+
+CREATE VIEW energy_hourly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM energy_hourly_cagg;
+
+CREATE VIEW power_hourly AS
+SELECT bucket, avg_power, max_power
+FROM power_hourly_cagg;
+
+CREATE VIEW current_hourly AS
+SELECT bucket, phase, avg_current, max_current
+FROM current_hourly_cagg;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+   tz TEXT := current_setting('tic.timezone');
+BEGIN
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW energy_daily_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', timestamp, %L) AS bucket,
+       tariff,
+       first(reading, timestamp) AS first_reading,
+       last(reading, timestamp) AS last_reading,
+       min(reading) AS min_reading,
+       max(reading) AS max_reading
+FROM energy
+GROUP BY bucket, tariff
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW power_daily_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', timestamp, %L) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW current_daily_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', timestamp, %L) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase
+WITH NO DATA$view$, tz);
+END
+$$;
+-- +goose StatementEnd
+
+SELECT add_continuous_aggregate_policy('energy_daily_cagg', start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 hour');
+SELECT add_continuous_aggregate_policy('power_daily_cagg', start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 hour');
+SELECT add_continuous_aggregate_policy('current_daily_cagg', start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 hour');
+
+CREATE VIEW energy_daily AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM energy_daily_cagg;
+
+CREATE VIEW power_daily AS
+SELECT bucket, avg_power, max_power
+FROM power_daily_cagg;
+
+CREATE VIEW current_daily AS
+SELECT bucket, phase, avg_current, max_current
+FROM current_daily_cagg;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+   tz TEXT := current_setting('tic.timezone');
+BEGIN
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW energy_monthly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 month', timestamp, %L) AS bucket,
+       tariff,
+       first(reading, timestamp) AS first_reading,
+       last(reading, timestamp) AS last_reading,
+       min(reading) AS min_reading,
+       max(reading) AS max_reading
+FROM energy
+GROUP BY bucket, tariff
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW power_monthly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 month', timestamp, %L) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket
+WITH NO DATA$view$, tz);
+
+   EXECUTE format($view$
+CREATE MATERIALIZED VIEW current_monthly_cagg
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 month', timestamp, %L) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase
+WITH NO DATA$view$, tz);
+END
+$$;
+-- +goose StatementEnd
+
+SELECT add_continuous_aggregate_policy('energy_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('power_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('current_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+
+CREATE VIEW energy_monthly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM energy_monthly_cagg;
+
+CREATE VIEW power_monthly AS
+SELECT bucket, avg_power, max_power
+FROM power_monthly_cagg;
+
+CREATE VIEW current_monthly AS
+SELECT bucket, phase, avg_current, max_current
+FROM current_monthly_cagg;
+
+CALL refresh_continuous_aggregate('energy_hourly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('power_hourly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('current_hourly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('energy_daily_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('power_daily_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('current_daily_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('energy_monthly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('power_monthly_cagg', NULL, NULL);
+CALL refresh_continuous_aggregate('current_monthly_cagg', NULL, NULL);
@@ -0,0 +1,58 @@
+-- +goose Up
+-- Standard-mode meters report the total consumed energy as EAST, next to
+-- the per tariff indexes EASF01-10. The drawn energy is computed from the
+-- historic indexes or from EAST, never from both kinds of labels at once.
+-- Buckets are in the timezone set by tic.timezone (sql.timezone in the
+-- configuration).
+DROP VIEW net_consumption;
+
+-- +goose StatementBegin
+DO $$
+BEGIN
+   EXECUTE format($view$
+CREATE VIEW net_consumption AS
+WITH drawn AS (
+   SELECT bucket, sum(consumed) AS consumed
+   FROM (
+      SELECT time_bucket('1 hour', timestamp, %1$L) AS bucket, max(reading) - min(reading) AS consumed
+      FROM energy
+      WHERE tariff IN ('BASE', 'HCHP', 'HCHC', 'EAST')
+      GROUP BY bucket, tariff
+   ) AS per_tariff
+   GROUP BY bucket
+), injected AS (
+   SELECT time_bucket('1 hour', timestamp, %1$L) AS bucket, max(reading) - min(reading) AS injected
+   FROM injected_energy
+   GROUP BY bucket
+)
+SELECT coalesce(drawn.bucket, injected.bucket) AS bucket,
+       coalesce(drawn.consumed, 0) AS consumed,
+       coalesce(injected.injected, 0) AS injected,
+       coalesce(drawn.consumed, 0) - coalesce(injected.injected, 0) AS net
+FROM drawn FULL OUTER JOIN injected ON drawn.bucket = injected.bucket$view$, current_setting('tic.timezone'));
+END
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP VIEW net_consumption;
+
+CREATE VIEW net_consumption AS
+WITH drawn AS (
+   SELECT bucket, sum(consumed) AS consumed
+   FROM (
+      SELECT time_bucket('1 hour', timestamp) AS bucket, max(reading) - min(reading) AS consumed
+      FROM energy
+      GROUP BY bucket, tariff
+   ) AS per_tariff
+   GROUP BY bucket
+), injected AS (
+   SELECT time_bucket('1 hour', timestamp) AS bucket, max(reading) - min(reading) AS injected
+   FROM injected_energy
+   GROUP BY bucket
+)
+SELECT coalesce(drawn.bucket, injected.bucket) AS bucket,
+       coalesce(drawn.consumed, 0) AS consumed,
+       coalesce(injected.injected, 0) AS injected,
+       coalesce(drawn.consumed, 0) - coalesce(injected.injected, 0) AS net
+FROM drawn FULL OUTER JOIN injected ON drawn.bucket = injected.bucket;
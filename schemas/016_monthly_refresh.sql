@@ -0,0 +1,18 @@
+-- +goose Up
+-- The monthly aggregates used to refresh the last 3 months, which forbade a
+-- retention of 90 days on the raw data they read. 80 days still cover two
+-- monthly buckets, as required by TimescaleDB.
+SELECT remove_continuous_aggregate_policy('energy_monthly_cagg');
+SELECT remove_continuous_aggregate_policy('power_monthly_cagg');
+SELECT remove_continuous_aggregate_policy('current_monthly_cagg');
+SELECT add_continuous_aggregate_policy('energy_monthly_cagg', start_offset => INTERVAL '80 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('power_monthly_cagg', start_offset => INTERVAL '80 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('current_monthly_cagg', start_offset => INTERVAL '80 days', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+
+-- +goose Down
+SELECT remove_continuous_aggregate_policy('energy_monthly_cagg');
+SELECT remove_continuous_aggregate_policy('power_monthly_cagg');
+SELECT remove_continuous_aggregate_policy('current_monthly_cagg');
+SELECT add_continuous_aggregate_policy('energy_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('power_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
+SELECT add_continuous_aggregate_policy('current_monthly_cagg', start_offset => INTERVAL '3 months', end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '1 day');
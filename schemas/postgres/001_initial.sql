@@ -0,0 +1,329 @@
+-- +goose Up
+-- Schema for PostgreSQL servers without the TimescaleDB extension (version 12
+-- or later). The tables that are hypertables with TimescaleDB are range
+-- partitioned by month instead: the processor creates the partitions ahead of
+-- time and the default partitions catch the values outside of them. The
+-- aggregates are plain views computed on the fly, bucketed in the timezone set
+-- by tic.timezone (sql.timezone in the configuration).
+CREATE TABLE current (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   phase       INTEGER NOT NULL DEFAULT(0),
+   current     BIGINT NOT NULL,
+   UNIQUE (timestamp, phase)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE power (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   power       BIGINT NOT NULL,
+   UNIQUE (timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE energy (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   tariff      TEXT NOT NULL,
+   reading     BIGINT NOT NULL,
+   UNIQUE (timestamp, tariff)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE injected_energy (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   reading     BIGINT NOT NULL,
+   UNIQUE (timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE injected_power (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   power       BIGINT NOT NULL,
+   UNIQUE (timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE reactive_energy (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   quadrant    INTEGER NOT NULL,
+   reading     BIGINT NOT NULL,
+   UNIQUE (timestamp, quadrant)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE voltage (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   phase       INTEGER NOT NULL,
+   kind        TEXT NOT NULL,
+   voltage     BIGINT NOT NULL,
+   UNIQUE (timestamp, phase, kind)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE downsampled (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   label       TEXT NOT NULL,
+   min         BIGINT NOT NULL,
+   avg         DOUBLE PRECISION NOT NULL,
+   max         BIGINT NOT NULL,
+   last        BIGINT NOT NULL,
+   samples     INTEGER NOT NULL,
+   UNIQUE (timestamp, label)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE current_default PARTITION OF current DEFAULT;
+CREATE TABLE power_default PARTITION OF power DEFAULT;
+CREATE TABLE energy_default PARTITION OF energy DEFAULT;
+CREATE TABLE injected_energy_default PARTITION OF injected_energy DEFAULT;
+CREATE TABLE injected_power_default PARTITION OF injected_power DEFAULT;
+CREATE TABLE reactive_energy_default PARTITION OF reactive_energy DEFAULT;
+CREATE TABLE voltage_default PARTITION OF voltage DEFAULT;
+CREATE TABLE downsampled_default PARTITION OF downsampled DEFAULT;
+
+CREATE TABLE overload (
+   start_time  TIMESTAMPTZ (3) NOT NULL,
+   end_time    TIMESTAMPTZ (3),
+   phase       INTEGER NOT NULL DEFAULT(0),
+   peak        BIGINT NOT NULL,
+   PRIMARY KEY (start_time, phase)
+);
+
+CREATE TABLE meter_status (
+   timestamp           TIMESTAMPTZ (3) NOT NULL PRIMARY KEY,
+   raw                 BIGINT NOT NULL,
+   contact_open        BOOLEAN NOT NULL,
+   cutoff_state        INTEGER NOT NULL,
+   cover_open          BOOLEAN NOT NULL,
+   overvoltage         BOOLEAN NOT NULL,
+   overpower           BOOLEAN NOT NULL,
+   producer            BOOLEAN NOT NULL,
+   negative_energy     BOOLEAN NOT NULL,
+   supplier_tariff     INTEGER NOT NULL,
+   distributor_tariff  INTEGER NOT NULL,
+   clock_degraded      BOOLEAN NOT NULL,
+   tic_standard        BOOLEAN NOT NULL,
+   euridis_state       INTEGER NOT NULL,
+   cpl_status          INTEGER NOT NULL,
+   cpl_synchronized    BOOLEAN NOT NULL,
+   tempo_today         INTEGER NOT NULL,
+   tempo_tomorrow      INTEGER NOT NULL,
+   mobile_peak_notice  INTEGER NOT NULL,
+   mobile_peak         INTEGER NOT NULL
+);
+
+CREATE TABLE voltage_event (
+   start_time  TIMESTAMPTZ (3) NOT NULL,
+   end_time    TIMESTAMPTZ (3),
+   phase       INTEGER NOT NULL,
+   kind        TEXT NOT NULL,
+   extreme     BIGINT NOT NULL,
+   PRIMARY KEY (start_time, phase)
+);
+
+CREATE TABLE meter_metadata (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   label       TEXT NOT NULL,
+   value       TEXT NOT NULL,
+   PRIMARY KEY (timestamp, label)
+);
+
+CREATE TABLE tariff_profile (
+   timestamp    TIMESTAMPTZ (3) NOT NULL,
+   switchover   TIME (0) WITHOUT TIME ZONE NOT NULL,
+   tariff_index INTEGER NOT NULL,
+   action       INTEGER NOT NULL,
+   PRIMARY KEY (timestamp, switchover)
+);
+
+CREATE TABLE meter_reset (
+   timestamp         TIMESTAMPTZ (3) NOT NULL,
+   tariff            TEXT NOT NULL,
+   previous_reading  BIGINT NOT NULL,
+   reading           BIGINT NOT NULL,
+   reading_offset    BIGINT NOT NULL,
+   PRIMARY KEY (timestamp, tariff)
+);
+
+CREATE TABLE quarantine (
+   timestamp   TIMESTAMPTZ (3) NOT NULL,
+   received    TIMESTAMPTZ (3),
+   label       TEXT NOT NULL,
+   value       TEXT NOT NULL,
+   reason      TEXT NOT NULL
+);
+
+CREATE INDEX ON quarantine (timestamp);
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+   tz TEXT := current_setting('tic.timezone');
+BEGIN
+   EXECUTE format($view$
+CREATE VIEW net_consumption AS
+WITH drawn AS (
+   SELECT bucket, sum(consumed) AS consumed
+   FROM (
+      SELECT date_trunc('hour', timestamp, %1$L) AS bucket, max(reading) - min(reading) AS consumed
+      FROM energy
+      WHERE tariff IN ('BASE', 'HCHP', 'HCHC', 'EAST')
+      GROUP BY bucket, tariff
+   ) AS per_tariff
+   GROUP BY bucket
+), injected AS (
+   SELECT date_trunc('hour', timestamp, %1$L) AS bucket, max(reading) - min(reading) AS injected
+   FROM injected_energy
+   GROUP BY bucket
+)
+SELECT coalesce(drawn.bucket, injected.bucket) AS bucket,
+       coalesce(drawn.consumed, 0) AS consumed,
+       coalesce(injected.injected, 0) AS injected,
+       coalesce(drawn.consumed, 0) - coalesce(injected.injected, 0) AS net
+FROM drawn FULL OUTER JOIN injected ON drawn.bucket = injected.bucket$view$, tz);
+END
+$$;
+-- +goose StatementEnd
+
+CREATE VIEW energy_monotonic AS
+SELECT e.timestamp,
+       e.tariff,
+       e.reading,
+       e.reading + coalesce((SELECT r.reading_offset FROM meter_reset r
+                             WHERE r.tariff = e.tariff AND r.timestamp <= e.timestamp
+                             ORDER BY r.timestamp DESC LIMIT 1), 0) AS monotonic_reading
+FROM energy e;
+
+-- When the index decreased within the bucket (meter replacement or counter
+-- wrap), count what was consumed before and after the reset.
+-- +goose StatementBegin
+DO $$
+DECLARE
+   tz TEXT := current_setting('tic.timezone');
+BEGIN
+   EXECUTE format($view$
+CREATE VIEW energy_hourly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM (
+   SELECT date_trunc('hour', timestamp, %1$L) AS bucket,
+          tariff,
+          (array_agg(reading ORDER BY timestamp))[1] AS first_reading,
+          (array_agg(reading ORDER BY timestamp DESC))[1] AS last_reading,
+          min(reading) AS min_reading,
+          max(reading) AS max_reading
+   FROM energy
+   GROUP BY bucket, tariff
+) AS per_bucket$view$, tz);
+
+   EXECUTE format($view$
+CREATE VIEW power_hourly AS
+SELECT date_trunc('hour', timestamp, %1$L) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket$view$, tz);
+
+   EXECUTE format($view$
+CREATE VIEW current_hourly AS
+SELECT date_trunc('hour', timestamp, %1$L) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase$view$, tz);
+
+   EXECUTE format($view$
+CREATE VIEW energy_daily AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM (
+   SELECT date_trunc('day', timestamp, %1$L) AS bucket,
+          tariff,
+          (array_agg(reading ORDER BY timestamp))[1] AS first_reading,
+          (array_agg(reading ORDER BY timestamp DESC))[1] AS last_reading,
+          min(reading) AS min_reading,
+          max(reading) AS max_reading
+   FROM energy
+   GROUP BY bucket, tariff
+) AS per_bucket$view$, tz);
+
+   EXECUTE format($view$
+CREATE VIEW power_daily AS
+SELECT date_trunc('day', timestamp, %1$L) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket$view$, tz);
+
+   EXECUTE format($view$
+CREATE VIEW current_daily AS
+SELECT date_trunc('day', timestamp, %1$L) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase$view$, tz);
+
+   EXECUTE format($view$
+CREATE VIEW energy_monthly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM (
+   SELECT date_trunc('month', timestamp, %1$L) AS bucket,
+          tariff,
+          (array_agg(reading ORDER BY timestamp))[1] AS first_reading,
+          (array_agg(reading ORDER BY timestamp DESC))[1] AS last_reading,
+          min(reading) AS min_reading,
+          max(reading) AS max_reading
+   FROM energy
+   GROUP BY bucket, tariff
+) AS per_bucket$view$, tz);
+
+   EXECUTE format($view$
+CREATE VIEW power_monthly AS
+SELECT date_trunc('month', timestamp, %1$L) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket$view$, tz);
+
+   EXECUTE format($view$
+CREATE VIEW current_monthly AS
+SELECT date_trunc('month', timestamp, %1$L) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase$view$, tz);
+END
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP VIEW current_monthly;
+DROP VIEW power_monthly;
+DROP VIEW energy_monthly;
+DROP VIEW current_daily;
+DROP VIEW power_daily;
+DROP VIEW energy_daily;
+DROP VIEW current_hourly;
+DROP VIEW power_hourly;
+DROP VIEW energy_hourly;
+DROP VIEW energy_monotonic;
+DROP VIEW net_consumption;
+DROP TABLE quarantine;
+DROP TABLE meter_reset;
+DROP TABLE tariff_profile;
+DROP TABLE meter_metadata;
+DROP TABLE voltage_event;
+DROP TABLE meter_status;
+DROP TABLE overload;
+DROP TABLE downsampled;
+DROP TABLE voltage;
+DROP TABLE reactive_energy;
+DROP TABLE injected_power;
+DROP TABLE injected_energy;
+DROP TABLE energy;
+DROP TABLE power;
+DROP TABLE current;
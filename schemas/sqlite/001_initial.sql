@@ -0,0 +1,292 @@
+-- +goose Up
+-- SQLite flavour of the schema, for a single meter logged without external
+-- services. Timestamps are stored as UTC text. SQLite has no timezone
+-- database: the buckets of the aggregates are computed in UTC as well.
+CREATE TABLE current (
+   timestamp   TIMESTAMP NOT NULL,
+   phase       INTEGER NOT NULL DEFAULT(0),
+   current     BIGINT NOT NULL,
+   UNIQUE (timestamp, phase)
+);
+
+CREATE TABLE power (
+   timestamp   TIMESTAMP UNIQUE NOT NULL,
+   power       BIGINT NOT NULL
+);
+
+CREATE TABLE energy (
+   timestamp   TIMESTAMP NOT NULL,
+   tariff      TEXT NOT NULL,
+   reading     BIGINT NOT NULL,
+   UNIQUE (timestamp, tariff)
+);
+
+CREATE TABLE overload (
+   start_time  TIMESTAMP NOT NULL,
+   end_time    TIMESTAMP,
+   phase       INTEGER NOT NULL DEFAULT(0),
+   peak        BIGINT NOT NULL,
+   PRIMARY KEY (start_time, phase)
+);
+
+CREATE TABLE meter_status (
+   timestamp           TIMESTAMP NOT NULL PRIMARY KEY,
+   raw                 BIGINT NOT NULL,
+   contact_open        BOOLEAN NOT NULL,
+   cutoff_state        INTEGER NOT NULL,
+   cover_open          BOOLEAN NOT NULL,
+   overvoltage         BOOLEAN NOT NULL,
+   overpower           BOOLEAN NOT NULL,
+   producer            BOOLEAN NOT NULL,
+   negative_energy     BOOLEAN NOT NULL,
+   supplier_tariff     INTEGER NOT NULL,
+   distributor_tariff  INTEGER NOT NULL,
+   clock_degraded      BOOLEAN NOT NULL,
+   tic_standard        BOOLEAN NOT NULL,
+   euridis_state       INTEGER NOT NULL,
+   cpl_status          INTEGER NOT NULL,
+   cpl_synchronized    BOOLEAN NOT NULL,
+   tempo_today         INTEGER NOT NULL,
+   tempo_tomorrow      INTEGER NOT NULL,
+   mobile_peak_notice  INTEGER NOT NULL,
+   mobile_peak         INTEGER NOT NULL
+);
+
+CREATE TABLE injected_energy (
+   timestamp   TIMESTAMP UNIQUE NOT NULL,
+   reading     BIGINT NOT NULL
+);
+
+CREATE TABLE injected_power (
+   timestamp   TIMESTAMP UNIQUE NOT NULL,
+   power       BIGINT NOT NULL
+);
+
+CREATE TABLE reactive_energy (
+   timestamp   TIMESTAMP NOT NULL,
+   quadrant    INTEGER NOT NULL,
+   reading     BIGINT NOT NULL,
+   UNIQUE (timestamp, quadrant)
+);
+
+CREATE TABLE voltage (
+   timestamp   TIMESTAMP NOT NULL,
+   phase       INTEGER NOT NULL,
+   kind        TEXT NOT NULL,
+   voltage     BIGINT NOT NULL,
+   UNIQUE (timestamp, phase, kind)
+);
+
+CREATE TABLE voltage_event (
+   start_time  TIMESTAMP NOT NULL,
+   end_time    TIMESTAMP,
+   phase       INTEGER NOT NULL,
+   kind        TEXT NOT NULL,
+   extreme     BIGINT NOT NULL,
+   PRIMARY KEY (start_time, phase)
+);
+
+CREATE TABLE meter_metadata (
+   timestamp   TIMESTAMP NOT NULL,
+   label       TEXT NOT NULL,
+   value       TEXT NOT NULL,
+   PRIMARY KEY (timestamp, label)
+);
+
+CREATE TABLE tariff_profile (
+   timestamp    TIMESTAMP NOT NULL,
+   switchover   TEXT NOT NULL,
+   tariff_index INTEGER NOT NULL,
+   action       INTEGER NOT NULL,
+   PRIMARY KEY (timestamp, switchover)
+);
+
+CREATE TABLE downsampled (
+   timestamp   TIMESTAMP NOT NULL,
+   label       TEXT NOT NULL,
+   min         BIGINT NOT NULL,
+   avg         DOUBLE PRECISION NOT NULL,
+   max         BIGINT NOT NULL,
+   last        BIGINT NOT NULL,
+   samples     INTEGER NOT NULL,
+   UNIQUE (timestamp, label)
+);
+
+CREATE TABLE meter_reset (
+   timestamp         TIMESTAMP NOT NULL,
+   tariff            TEXT NOT NULL,
+   previous_reading  BIGINT NOT NULL,
+   reading           BIGINT NOT NULL,
+   reading_offset    BIGINT NOT NULL,
+   PRIMARY KEY (timestamp, tariff)
+);
+
+CREATE TABLE quarantine (
+   timestamp   TIMESTAMP NOT NULL,
+   received    TIMESTAMP,
+   label       TEXT NOT NULL,
+   value       TEXT NOT NULL,
+   reason      TEXT NOT NULL
+);
+
+CREATE INDEX quarantine_timestamp_idx ON quarantine (timestamp);
+
+CREATE VIEW net_consumption AS
+WITH drawn AS (
+   SELECT bucket, sum(consumed) AS consumed
+   FROM (
+      SELECT strftime('%Y-%m-%d %H:00:00', timestamp) AS bucket, max(reading) - min(reading) AS consumed
+      FROM energy
+      WHERE tariff IN ('BASE', 'HCHP', 'HCHC', 'EAST')
+      GROUP BY bucket, tariff
+   ) AS per_tariff
+   GROUP BY bucket
+), injected AS (
+   SELECT strftime('%Y-%m-%d %H:00:00', timestamp) AS bucket, max(reading) - min(reading) AS injected
+   FROM injected_energy
+   GROUP BY bucket
+), buckets AS (
+   SELECT bucket FROM drawn UNION SELECT bucket FROM injected
+)
+SELECT buckets.bucket,
+       coalesce(drawn.consumed, 0) AS consumed,
+       coalesce(injected.injected, 0) AS injected,
+       coalesce(drawn.consumed, 0) - coalesce(injected.injected, 0) AS net
+FROM buckets
+LEFT JOIN drawn ON drawn.bucket = buckets.bucket
+LEFT JOIN injected ON injected.bucket = buckets.bucket;
+
+CREATE VIEW energy_monotonic AS
+SELECT e.timestamp,
+       e.tariff,
+       e.reading,
+       e.reading + coalesce((SELECT r.reading_offset FROM meter_reset r
+                             WHERE r.tariff = e.tariff AND r.timestamp <= e.timestamp
+                             ORDER BY r.timestamp DESC LIMIT 1), 0) AS monotonic_reading
+FROM energy e;
+
+-- The energy aggregates handle counter resets the same way as the
+-- TimescaleDB continuous aggregates.
+CREATE VIEW energy_hourly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM (
+   SELECT DISTINCT bucket,
+          tariff,
+          first_value(reading) OVER w AS first_reading,
+          last_value(reading) OVER w AS last_reading,
+          min(reading) OVER w AS min_reading,
+          max(reading) OVER w AS max_reading
+   FROM (SELECT strftime('%Y-%m-%d %H:00:00', timestamp) AS bucket, timestamp, tariff, reading FROM energy)
+   WINDOW w AS (PARTITION BY bucket, tariff ORDER BY timestamp ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING)
+);
+
+CREATE VIEW power_hourly AS
+SELECT strftime('%Y-%m-%d %H:00:00', timestamp) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket;
+
+CREATE VIEW current_hourly AS
+SELECT strftime('%Y-%m-%d %H:00:00', timestamp) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase;
+
+CREATE VIEW energy_daily AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM (
+   SELECT DISTINCT bucket,
+          tariff,
+          first_value(reading) OVER w AS first_reading,
+          last_value(reading) OVER w AS last_reading,
+          min(reading) OVER w AS min_reading,
+          max(reading) OVER w AS max_reading
+   FROM (SELECT date(timestamp) AS bucket, timestamp, tariff, reading FROM energy)
+   WINDOW w AS (PARTITION BY bucket, tariff ORDER BY timestamp ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING)
+);
+
+CREATE VIEW power_daily AS
+SELECT date(timestamp) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket;
+
+CREATE VIEW current_daily AS
+SELECT date(timestamp) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase;
+
+CREATE VIEW energy_monthly AS
+SELECT bucket,
+       tariff,
+       CASE WHEN last_reading >= first_reading THEN last_reading - first_reading
+            ELSE (max_reading - first_reading) + (last_reading - min_reading)
+       END AS consumption
+FROM (
+   SELECT DISTINCT bucket,
+          tariff,
+          first_value(reading) OVER w AS first_reading,
+          last_value(reading) OVER w AS last_reading,
+          min(reading) OVER w AS min_reading,
+          max(reading) OVER w AS max_reading
+   FROM (SELECT strftime('%Y-%m-01', timestamp) AS bucket, timestamp, tariff, reading FROM energy)
+   WINDOW w AS (PARTITION BY bucket, tariff ORDER BY timestamp ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING)
+);
+
+CREATE VIEW power_monthly AS
+SELECT strftime('%Y-%m-01', timestamp) AS bucket,
+       avg(power) AS avg_power,
+       max(power) AS max_power
+FROM power
+GROUP BY bucket;
+
+CREATE VIEW current_monthly AS
+SELECT strftime('%Y-%m-01', timestamp) AS bucket,
+       phase,
+       avg(current) AS avg_current,
+       max(current) AS max_current
+FROM current
+GROUP BY bucket, phase;
+
+-- +goose Down
+DROP VIEW current_monthly;
+DROP VIEW power_monthly;
+DROP VIEW energy_monthly;
+DROP VIEW current_daily;
+DROP VIEW power_daily;
+DROP VIEW energy_daily;
+DROP VIEW current_hourly;
+DROP VIEW power_hourly;
+DROP VIEW energy_hourly;
+DROP VIEW energy_monotonic;
+DROP VIEW net_consumption;
+DROP TABLE quarantine;
+DROP TABLE meter_reset;
+DROP TABLE downsampled;
+DROP TABLE tariff_profile;
+DROP TABLE meter_metadata;
+DROP TABLE voltage_event;
+DROP TABLE voltage;
+DROP TABLE reactive_energy;
+DROP TABLE injected_power;
+DROP TABLE injected_energy;
+DROP TABLE meter_status;
+DROP TABLE overload;
+DROP TABLE energy;
+DROP TABLE power;
+DROP TABLE current;
@@ -0,0 +1,261 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A Point is a TIC value ready to be stored
+type Point struct {
+	Table     string                 // destination table (current, power, energy, ...)
+	Timestamp time.Time              // timestamp of the value
+	Tags      map[string]string      // dimensions of the value (phase, tariff, ...)
+	Fields    map[string]interface{} // values (int64 or float64)
+	Label     string                 // TIC label the value comes from, empty for aggregates
+	source    *TicMessage            // message the value comes from, confirmed to the dedup cache once stored
+	queries   []statement            // SQL statements run by the database sink instead of storing a value
+}
+
+// A statement is an SQL Query and its arguments
+type statement struct {
+	query string
+	args  []interface{}
+}
+
+// A Sink stores points to a storage backend
+type Sink interface {
+	Write(points []Point) error // stores a batch of points
+	Flush() error               // makes sure all written points are persisted
+	Close() error               // flushes and releases the resources held by the sink
+}
+
+// A SinkConfig stores how points are batched before being sent to the sinks
+type SinkConfig struct {
+	BatchSize     int           // how many points to send in one batch
+	FlushInterval time.Duration // how long to wait for a batch to fill up
+	QueueLength   int           // how many pending writes can be queued for a sink before the processing blocks
+}
+
+// A sinkRunner feeds a sink from its own go routine, so that a slow or
+// failing sink does not delay the others
+type sinkRunner struct {
+	name      string         // name of the sink, for logging
+	sink      Sink           // the sink
+	processor *Processor     // the processor feeding the sink
+	batches   chan []Point   // points waiting to be written
+	done      sync.WaitGroup // signals the end of the go routine
+	confirm   bool           // whether successful writes are confirmed to the dedup cache
+}
+
+// A PartialWriteError reports the points of a batch rejected by a sink that
+// stored the other ones
+type PartialWriteError struct {
+	Rejected []bool // whether each point of the batch has been rejected
+	Err      error  // the first rejection
+}
+
+func (e *PartialWriteError) Error() string {
+	var rejected int
+	for _, r := range e.Rejected {
+		if r {
+			rejected++
+		}
+	}
+	return fmt.Sprintf("%d of %d point(s) rejected: %s", rejected, len(e.Rejected), e.Err)
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// NamedSink associates a name with a sink, for logging purposes
+type NamedSink struct {
+	Name string
+	Sink Sink
+}
+
+// A writeConfirmations collects the messages stored by the database sink,
+// until the processor records them in its dedup cache
+type writeConfirmations struct {
+	sync.Mutex
+	messages []TicMessage
+}
+
+// checkSinkConfig returns an error if the batching settings are invalid
+func (processor *Processor) checkSinkConfig() error {
+	config := processor.Config.Sink
+	if config.BatchSize <= 0 {
+		return fmt.Errorf("invalid sink batch size: %d", config.BatchSize)
+	}
+	if config.FlushInterval <= 0 {
+		return fmt.Errorf("invalid sink flush interval: %s", config.FlushInterval)
+	}
+	if config.QueueLength < 0 {
+		return fmt.Errorf("invalid sink queue length: %d", config.QueueLength)
+	}
+	return nil
+}
+
+// startSinks starts a go routine for each sink. The writes of the first sink
+// are confirmed to the dedup cache.
+func (processor *Processor) startSinks(sinks []NamedSink) {
+	for i, s := range sinks {
+		runner := &sinkRunner{
+			name:      s.Name,
+			sink:      s.Sink,
+			processor: processor,
+			batches:   make(chan []Point, processor.Config.Sink.QueueLength),
+			confirm:   i == 0,
+		}
+		runner.done.Add(1)
+		go runner.run()
+		processor.sinks = append(processor.sinks, runner)
+	}
+}
+
+// closeSinks waits for the pending points to be written and closes the sinks
+func (processor *Processor) closeSinks() {
+	for _, runner := range processor.sinks {
+		close(runner.batches)
+	}
+	for _, runner := range processor.sinks {
+		runner.done.Wait()
+		if err := runner.sink.Close(); err != nil {
+			processor.Config.Logger.Printf("%s: %s", runner.name, err)
+		}
+	}
+	processor.sinks = nil
+}
+
+// emit sends points to all sinks. When the queue of a sink is full, the
+// processing waits for the sink to catch up.
+func (processor *Processor) emit(points ...Point) {
+	for _, runner := range processor.sinks {
+		select {
+		case runner.batches <- points:
+		default:
+			processor.Config.Logger.Printf("%s: queue is full, waiting for pending writes...", runner.name)
+			runner.batches <- points
+		}
+	}
+}
+
+// queueStatements sends SQL statements to the database sink, which runs them
+// in order and in a single transaction. The processing of the messages does
+// not wait for the database: a failure is logged by the sink.
+func (processor *Processor) queueStatements(queries ...statement) {
+	runner := processor.sinks[0]
+	points := []Point{{queries: queries}}
+	select {
+	case runner.batches <- points:
+	default:
+		processor.Config.Logger.Printf("%s: queue is full, waiting for pending writes...", runner.name)
+		runner.batches <- points
+	}
+}
+
+// confirmWrites records the messages stored by the database sink in the
+// dedup cache
+func (processor *Processor) confirmWrites() {
+	processor.confirmations.Lock()
+	messages := processor.confirmations.messages
+	processor.confirmations.messages = nil
+	processor.confirmations.Unlock()
+
+	for _, msg := range messages {
+		processor.recordWrite(msg)
+	}
+}
+
+// run accumulates points until the batch is full or the flush interval is
+// elapsed and writes them to the sink
+func (runner *sinkRunner) run() {
+	defer runner.done.Done()
+
+	config := runner.processor.Config.Sink
+	ticker := time.NewTicker(config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []Point
+	for {
+		select {
+		case points, ok := <-runner.batches:
+			if !ok {
+				runner.write(batch)
+				return
+			}
+			batch = append(batch, points...)
+			if len(batch) < config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		runner.write(batch)
+		batch = nil
+	}
+}
+
+// write sends a batch to the sink, retrying on transient errors. The batch
+// is dropped when the sink keeps failing. When the sink rejects some points
+// only, the other ones are confirmed.
+func (runner *sinkRunner) write(batch []Point) {
+	if len(batch) == 0 {
+		return
+	}
+
+	rejected := make([]bool, len(batch))
+	err := runner.processor.retry(runner.name, func() error {
+		return runner.sink.Write(batch)
+	})
+	var partial *PartialWriteError
+	if errors.As(err, &partial) {
+		runner.processor.Config.Logger.Printf("%s: batch of %d point(s): %s", runner.name, len(batch), err)
+		rejected = partial.Rejected
+		err = nil
+	}
+	if err == nil {
+		err = runner.processor.retry(runner.name, runner.sink.Flush)
+	}
+	if err != nil {
+		runner.processor.Config.Logger.Printf("%s: batch of %d point(s): %s", runner.name, len(batch), err)
+		return
+	}
+
+	if runner.confirm {
+		confirmations := &runner.processor.confirmations
+		confirmations.Lock()
+		for i, point := range batch {
+			if rejected[i] || point.source == nil {
+				continue
+			}
+			if _, ok := runner.processor.Config.Dedup[point.source.Field]; ok {
+				confirmations.messages = append(confirmations.messages, *point.source)
+			}
+		}
+		confirmations.Unlock()
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// A rawTable describes a table storing TIC values
+type rawTable struct {
+	tags   []string // columns holding the dimensions, part of the unique constraint
+	fields []string // columns holding the values
+	index  bool     // whether the value is an ever increasing index
+}
+
+// rawTables lists the tables storing TIC values
+var rawTables map[string]rawTable = map[string]rawTable{
+	"current":         {tags: []string{"phase"}, fields: []string{"current"}},
+	"power":           {fields: []string{"power"}},
+	"energy":          {tags: []string{"tariff"}, fields: []string{"reading"}, index: true},
+	"injected_energy": {fields: []string{"reading"}, index: true},
+	"injected_power":  {fields: []string{"power"}},
+	"reactive_energy": {tags: []string{"quadrant"}, fields: []string{"reading"}, index: true},
+	"voltage":         {tags: []string{"phase", "kind"}, fields: []string{"voltage"}},
+	"downsampled":     {tags: []string{"label"}, fields: []string{"min", "avg", "max", "last", "samples"}},
+}
+
+// An SqlSink stores points in the TimescaleDB tables
+type SqlSink struct {
+	db      *sql.DB           // the database connection
+	upserts map[string]string // SQL Queries storing points, by table
+}
+
+// NewSqlSink creates a sink writing to the database, handling the conflicts
+// according to the policies
+func NewSqlSink(db *sql.DB, driver string, conflicts map[string]ConflictPolicy) (*SqlSink, error) {
+	for name := range conflicts {
+		if _, ok := rawTables[name]; !ok {
+			return nil, fmt.Errorf("no conflict policy can be set on table %s", name)
+		}
+	}
+
+	sink := SqlSink{
+		db:      db,
+		upserts: make(map[string]string),
+	}
+	for name, table := range rawTables {
+		policy := conflicts[name].Policy
+		if policy == "" {
+			policy = CONFLICT_OVERWRITE
+		}
+
+		query, err := upsertQuery(name, table, policy, driver)
+		if err != nil {
+			return nil, err
+		}
+		sink.upserts[name] = query
+	}
+
+	return &sink, nil
+}
+
+// upsertQuery builds the SQL Query storing a point in a table according to
+// the conflict policy
+func upsertQuery(name string, table rawTable, policy, driver string) (string, error) {
+	columns := 1 + len(table.tags) + len(table.fields)
+	placeholders := make([]string, columns)
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	key := strings.Join(append([]string{"timestamp"}, table.tags...), ", ")
+	query := fmt.Sprintf(`
+	INSERT INTO %s VALUES (%s)
+	ON CONFLICT (%s) `, name, strings.Join(placeholders, ", "), key)
+
+	var updates []string
+	switch policy {
+	case CONFLICT_OVERWRITE, CONFLICT_REJECT_OLD:
+		for _, field := range table.fields {
+			updates = append(updates, fmt.Sprintf("%[1]s = excluded.%[1]s", field))
+		}
+	case CONFLICT_KEEP_FIRST:
+		return query + "DO NOTHING", nil
+	case CONFLICT_KEEP_MAX:
+		if !table.index {
+			return "", fmt.Errorf("conflict policy %s is only supported for energy tables, not %s", policy, name)
+		}
+		// SQLite has no GREATEST but its MAX function accepts several arguments
+		greatest := "GREATEST"
+		if driver == DRIVER_SQLITE {
+			greatest = "MAX"
+		}
+		for _, field := range table.fields {
+			updates = append(updates, fmt.Sprintf("%[3]s = %[1]s(%[2]s.%[3]s, excluded.%[3]s)", greatest, name, field))
+		}
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q for %s", policy, name)
+	}
+
+	return query + "DO UPDATE\n    SET " + strings.Join(updates, ", "), nil
+}
+
+// Write stores a batch of points in a single transaction. When the batch is
+// rejected, the points are written one by one so that a single bad point
+// does not discard the others: a PartialWriteError then tells which points
+// have been rejected.
+func (sink *SqlSink) Write(points []Point) error {
+	err := sink.writeBatch(points)
+	if err == nil || IsTransientError(err) || len(points) == 1 {
+		return err
+	}
+
+	partial := PartialWriteError{Rejected: make([]bool, len(points))}
+	for i, point := range points {
+		if err := sink.writeBatch([]Point{point}); err != nil {
+			if IsTransientError(err) {
+				return err
+			}
+			if partial.Err == nil {
+				partial.Err = err
+			}
+			partial.Rejected[i] = true
+		}
+	}
+	if partial.Err != nil {
+		return &partial
+	}
+
+	return nil
+}
+
+// writeBatch stores a batch of points in a single transaction
+func (sink *SqlSink) writeBatch(points []Point) error {
+	tx, err := sink.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, point := range points {
+		for _, query := range point.queries {
+			if _, err := tx.Exec(query.query, utcArgs(query.args)...); err != nil {
+				return err
+			}
+		}
+		if point.queries != nil {
+			continue
+		}
+
+		table, ok := rawTables[point.Table]
+		if !ok {
+			return fmt.Errorf("unknown table %s", point.Table)
+		}
+
+		args := []interface{}{point.Timestamp.UTC()}
+		for _, tag := range table.tags {
+			args = append(args, point.Tags[tag])
+		}
+		for _, field := range table.fields {
+			args = append(args, point.Fields[field])
+		}
+
+		if _, err := tx.Exec(sink.upserts[point.Table], args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Flush does nothing since points are committed by Write
+func (sink *SqlSink) Flush() error {
+	return nil
+}
+
+// Close does nothing since the database connection is owned by the processor
+func (sink *SqlSink) Close() error {
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// A MeterStatus is the decoded form of the STGE status register sent by
+// Linky meters in standard mode.
+type MeterStatus struct {
+	Raw               uint32 // the register, as received
+	ContactOpen       bool   // bit 0: dry contact is open
+	CutoffState       int    // bits 1-3: cut-off relay state (0 = closed, 1 = open on over-power, 2 = open on overvoltage, 3 = load shedding, 4 = CPL / Euridis order, 5-6 = overheating)
+	CoverOpen         bool   // bit 4: distributor terminal cover is open
+	Overvoltage       bool   // bit 6: overvoltage on one of the phases
+	Overpower         bool   // bit 7: reference power exceeded
+	Producer          bool   // bit 8: meter operates in producer mode
+	NegativeEnergy    bool   // bit 9: active energy flows towards the grid
+	SupplierTariff    int    // bits 10-13: supplier tariff index in progress (1-10)
+	DistributorTariff int    // bits 14-15: distributor tariff index in progress (1-4)
+	ClockDegraded     bool   // bit 16: the meter clock is in degraded mode
+	TicStandard       bool   // bit 17: TIC output is in standard mode
+	EuridisState      int    // bits 19-20: Euridis output (0 = disabled, 1 = enabled, 3 = enabled with security)
+	CplStatus         int    // bits 21-22: CPL status (0 = new/unlock, 1 = new/lock, 2 = registered)
+	CplSynchronized   bool   // bit 23: CPL is synchronized
+	TempoToday        int    // bits 24-25: tempo colour of the day (0 = none, 1 = blue, 2 = white, 3 = red)
+	TempoTomorrow     int    // bits 26-27: tempo colour of the next day
+	MobilePeakNotice  int    // bits 28-29: notice of mobile peak (0 = none, 1-3 = PM1-PM3)
+	MobilePeak        int    // bits 30-31: mobile peak in progress (0 = none, 1-3 = PM1-PM3)
+}
+
+const (
+	// SQL Query to store the meter status
+	UpsertMeterStatusQuery string = `
+	INSERT INTO meter_status VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+	ON CONFLICT (timestamp) DO NOTHING`
+
+	// SQL Query to retrieve the last known meter status
+	SelectLastMeterStatusQuery string = `
+	SELECT raw FROM meter_status ORDER BY timestamp DESC LIMIT 1`
+)
+
+// bits extracts width bits starting at offset from the register
+func bits(register uint32, offset, width uint) int {
+	return int((register >> offset) & (1<<width - 1))
+}
+
+// ParseMeterStatus decodes the STGE hexadecimal register
+func ParseMeterStatus(value string) (MeterStatus, error) {
+	raw, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return MeterStatus{}, err
+	}
+
+	r := uint32(raw)
+	return MeterStatus{
+		Raw:               r,
+		ContactOpen:       bits(r, 0, 1) == 1,
+		CutoffState:       bits(r, 1, 3),
+		CoverOpen:         bits(r, 4, 1) == 1,
+		Overvoltage:       bits(r, 6, 1) == 1,
+		Overpower:         bits(r, 7, 1) == 1,
+		Producer:          bits(r, 8, 1) == 1,
+		NegativeEnergy:    bits(r, 9, 1) == 1,
+		SupplierTariff:    bits(r, 10, 4) + 1,
+		DistributorTariff: bits(r, 14, 2) + 1,
+		ClockDegraded:     bits(r, 16, 1) == 1,
+		TicStandard:       bits(r, 17, 1) == 1,
+		EuridisState:      bits(r, 19, 2),
+		CplStatus:         bits(r, 21, 2),
+		CplSynchronized:   bits(r, 23, 1) == 1,
+		TempoToday:        bits(r, 24, 2),
+		TempoTomorrow:     bits(r, 26, 2),
+		MobilePeakNotice:  bits(r, 28, 2),
+		MobilePeak:        bits(r, 30, 2),
+	}, nil
+}
+
+// processMeterStatus saves the decoded STGE register to the database, when
+// it differs from the last known value.
+func (processor *Processor) processMeterStatus(msg TicMessage) error {
+	status, err := ParseMeterStatus(msg.Value)
+	if err != nil {
+		return err
+	}
+
+	if processor.lastStatus == nil {
+		var raw int64
+		err := processor.retryWrite(func() error {
+			return processor.conn.QueryRow(SelectLastMeterStatusQuery).Scan(&raw)
+		})
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil {
+			last := uint32(raw)
+			processor.lastStatus = &last
+		}
+	}
+
+	if processor.lastStatus != nil && *processor.lastStatus == status.Raw {
+		return nil
+	}
+
+	processor.exec(UpsertMeterStatusQuery,
+		time.Time(msg.Timestamp),
+		int64(status.Raw),
+		status.ContactOpen,
+		status.CutoffState,
+		status.CoverOpen,
+		status.Overvoltage,
+		status.Overpower,
+		status.Producer,
+		status.NegativeEnergy,
+		status.SupplierTariff,
+		status.DistributorTariff,
+		status.ClockDegraded,
+		status.TicStandard,
+		status.EuridisState,
+		status.CplStatus,
+		status.CplSynchronized,
+		status.TempoToday,
+		status.TempoTomorrow,
+		status.MobilePeakNotice,
+		status.MobilePeak)
+
+	processor.lastStatus = &status.Raw
+	return nil
+}
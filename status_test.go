@@ -0,0 +1,97 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"testing"
+)
+
+func TestParseMeterStatus(t *testing.T) {
+	tests := []struct {
+		input string
+		want  MeterStatus
+	}{
+		{"00000000", MeterStatus{SupplierTariff: 1, DistributorTariff: 1}},
+		{"003A4401", MeterStatus{
+			Raw:               0x003A4401,
+			ContactOpen:       true,
+			SupplierTariff:    2,
+			DistributorTariff: 2,
+			TicStandard:       true,
+			EuridisState:      3,
+			CplStatus:         1,
+		}},
+		{"9A0041D2", MeterStatus{
+			Raw:               0x9A0041D2,
+			CutoffState:       1,
+			CoverOpen:         true,
+			Overvoltage:       true,
+			Overpower:         true,
+			Producer:          true,
+			SupplierTariff:    1,
+			DistributorTariff: 2,
+			TempoToday:        2,
+			TempoTomorrow:     2,
+			MobilePeakNotice:  1,
+			MobilePeak:        2,
+		}},
+		{"FFFFFFFF", MeterStatus{
+			Raw:               0xFFFFFFFF,
+			ContactOpen:       true,
+			CutoffState:       7,
+			CoverOpen:         true,
+			Overvoltage:       true,
+			Overpower:         true,
+			Producer:          true,
+			NegativeEnergy:    true,
+			SupplierTariff:    16,
+			DistributorTariff: 4,
+			ClockDegraded:     true,
+			TicStandard:       true,
+			EuridisState:      3,
+			CplStatus:         3,
+			CplSynchronized:   true,
+			TempoToday:        3,
+			TempoTomorrow:     3,
+			MobilePeakNotice:  3,
+			MobilePeak:        3,
+		}},
+	}
+	for _, test := range tests {
+		status, err := ParseMeterStatus(test.input)
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err)
+			continue
+		}
+		if status != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.input, status, test.want)
+		}
+	}
+}
+
+func TestParseMeterStatusErrors(t *testing.T) {
+	for _, input := range []string{"", "XYZ", "100000000", "-1"} {
+		if status, err := ParseMeterStatus(input); err == nil {
+			t.Errorf("%q: expected an error, got %+v", input, status)
+		}
+	}
+}
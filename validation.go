@@ -0,0 +1,198 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Bounds stores the range of acceptable values of a label
+type Bounds struct {
+	Min int64 // lowest acceptable value
+	Max int64 // highest acceptable value
+}
+
+// A ValidationConfig stores the plausibility checks to run on incoming values
+type ValidationConfig struct {
+	Bounds               map[string]Bounds // acceptable range, by label
+	EnergyRateMargin     float64           // tolerance factor on the maximum increase of an energy index (0 = no check)
+	ConsistencyTolerance float64           // relative tolerance between PAPP and the sum of IINST × voltage (0 = no check)
+	NominalVoltage       int64             // voltage used to convert currents into power (V)
+}
+
+// A currentReading is the last known current of a phase
+type currentReading struct {
+	value     int64     // current (A)
+	timestamp time.Time // timestamp of the reading
+}
+
+const (
+	// How recent the currents have to be to check the consistency of PAPP
+	CONSISTENCY_MAX_DELAY = 10 * time.Second
+
+	// SQL Query to store a rejected value
+	InsertQuarantineQuery string = `
+	INSERT INTO quarantine VALUES ($1, $2, $3, $4, $5)`
+)
+
+// validate runs the plausibility checks on the message and returns the
+// reason why it is rejected, or an empty string if it is accepted.
+func (processor *Processor) validate(msg TicMessage) (string, error) {
+	config := processor.Config.Validation
+	value, err := msg.Integer()
+	numeric := err == nil
+
+	if bounds, ok := config.Bounds[msg.Field]; ok {
+		if !numeric {
+			return fmt.Sprintf("%q is not a number", msg.Value), nil
+		}
+		if value < bounds.Min || value > bounds.Max {
+			return fmt.Sprintf("%d is out of bounds [%d, %d]", value, bounds.Min, bounds.Max), nil
+		}
+	}
+	if !numeric {
+		return "", nil
+	}
+
+	ts := time.Time(msg.Timestamp)
+	switch {
+	case msg.Field == "ISOUSC":
+		processor.subscribedPower = value * config.NominalVoltage
+		processor.perPhasePower = true
+	case msg.Field == "PREF":
+		processor.subscribedPower = value * 1000
+		processor.perPhasePower = false
+	case msg.Field == "IINST" || msg.Field == "IINST1" || msg.Field == "IINST2" || msg.Field == "IINST3":
+		processor.lastCurrents[msg.Field] = currentReading{value: value, timestamp: ts}
+	case msg.Field == "PAPP":
+		return processor.checkPowerConsistency(ts, value), nil
+	case energyLabels[msg.Field]:
+		if reason, err := processor.checkEnergyDecrease(msg, value); reason != "" || err != nil {
+			return reason, err
+		}
+		return processor.checkEnergyRate(msg, value)
+	}
+
+	return "", nil
+}
+
+// checkEnergyDecrease rejects an energy index lower than the previous reading
+// until enough consecutive readings confirm the decrease as a meter reset. The
+// rejected readings are still counted as confirmations.
+func (processor *Processor) checkEnergyDecrease(msg TicMessage, value int64) (string, error) {
+	index, err := processor.getEnergyIndex(msg.Field)
+	if err != nil {
+		return "", err
+	}
+
+	ts := time.Time(msg.Timestamp)
+	confirmations := processor.Config.Reset.Confirmations
+	if !index.pendingDecrease(value, ts, confirmations) {
+		return "", nil
+	}
+
+	index.countLower(value, ts)
+	return fmt.Sprintf("decrease from %d to %d Wh is not confirmed yet (%d of %d readings)", index.reading, value, index.lower, confirmations), nil
+}
+
+// checkEnergyRate rejects an energy index that increased more than what the
+// subscribed power allows since the previous reading
+func (processor *Processor) checkEnergyRate(msg TicMessage, value int64) (string, error) {
+	margin := processor.Config.Validation.EnergyRateMargin
+	if margin == 0 || processor.subscribedPower == 0 {
+		return "", nil
+	}
+
+	index, err := processor.getEnergyIndex(msg.Field)
+	if err != nil {
+		return "", err
+	}
+
+	ts := time.Time(msg.Timestamp)
+	if index.reading < 0 || !ts.After(index.timestamp) || value <= index.reading {
+		return "", nil
+	}
+
+	// ISOUSC is the subscribed current per phase of three-phase meters, PREF
+	// is the total subscribed power
+	power := processor.subscribedPower
+	if _, ok := processor.lastCurrents["IINST1"]; ok && processor.perPhasePower {
+		power *= 3
+	}
+
+	elapsed := ts.Sub(index.timestamp).Hours()
+	allowed := float64(power)*elapsed*margin + 1
+	if increase := value - index.reading; float64(increase) > allowed {
+		return fmt.Sprintf("increase of %d Wh in %s exceeds %.0f Wh", increase, ts.Sub(index.timestamp), allowed), nil
+	}
+
+	return "", nil
+}
+
+// checkPowerConsistency rejects an apparent power that does not match the
+// sum of the currents multiplied by the nominal voltage
+func (processor *Processor) checkPowerConsistency(ts time.Time, value int64) string {
+	config := processor.Config.Validation
+	if config.ConsistencyTolerance == 0 {
+		return ""
+	}
+
+	var sum, phases int64
+	for _, current := range processor.lastCurrents {
+		delay := ts.Sub(current.timestamp)
+		if delay < -CONSISTENCY_MAX_DELAY || delay > CONSISTENCY_MAX_DELAY {
+			continue
+		}
+		sum += current.value
+		phases++
+	}
+	if phases == 0 {
+		return ""
+	}
+
+	// IINST is rounded to the ampere, allow for that on each phase
+	expected := sum * config.NominalVoltage
+	slack := config.ConsistencyTolerance*float64(expected) + float64(phases*config.NominalVoltage)
+	if diff := float64(value - expected); diff > slack || diff < -slack {
+		return fmt.Sprintf("%d VA does not match %d A × %d V", value, sum, config.NominalVoltage)
+	}
+
+	return ""
+}
+
+// quarantine stores a rejected value along with the reason of its rejection
+func (processor *Processor) quarantine(msg TicMessage, reason string) {
+	processor.Config.Logger.Printf("Quarantined %s = %s: %s", msg.Field, msg.Value, reason)
+
+	var received interface{}
+	if !msg.Received.IsZero() {
+		received = msg.Received
+	}
+
+	processor.exec(InsertQuarantineQuery,
+		time.Time(msg.Timestamp),
+		received,
+		msg.Field,
+		msg.Value,
+		reason)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright © 2022 Nicolas MASSE
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package lib
+
+import (
+	"time"
+)
+
+// A VoltageConfig stores the settings of the sag / swell detector
+type VoltageConfig struct {
+	Min      int64         // lowest acceptable voltage (V)
+	Max      int64         // highest acceptable voltage (V)
+	Duration time.Duration // how long the voltage has to stay out of bounds before an event is recorded
+}
+
+// A VoltageEvent represents a period during which the voltage of a phase was
+// out of the configured bounds
+type VoltageEvent struct {
+	Start    time.Time // first out of bounds reading
+	Phase    int       // phase number (1-3)
+	Kind     string    // VOLTAGE_SAG or VOLTAGE_SWELL
+	Extreme  int64     // lowest voltage for a sag, highest for a swell
+	recorded bool      // whether the event has been saved to the database
+}
+
+const (
+	// Kinds of voltage events
+	VOLTAGE_SAG   = "sag"
+	VOLTAGE_SWELL = "swell"
+
+	// SQL Query to store the beginning of a sag / swell
+	InsertVoltageEventQuery string = `
+	INSERT INTO voltage_event VALUES ($1, NULL, $2, $3, $4)
+	ON CONFLICT (start_time, phase) DO UPDATE
+    SET extreme = excluded.extreme`
+
+	// SQL Query to update an on-going or finished sag / swell
+	UpdateVoltageEventQuery string = `
+	UPDATE voltage_event SET end_time = $3, extreme = $4
+	WHERE start_time = $1 AND phase = $2`
+
+	// SQL Query to get the sags / swells left open by a previous run
+	SelectOpenVoltageEventsQuery string = `
+	SELECT start_time, phase, kind, extreme FROM voltage_event WHERE end_time IS NULL ORDER BY start_time`
+)
+
+// loadVoltageEvents reloads the sags / swells left open by a previous run, so
+// that they are either continued or closed by the next URMS reading of their
+// phase. When several are open on the same phase, the older ones are closed
+// at the start of the next one.
+func (processor *Processor) loadVoltageEvents() error {
+	var events []*VoltageEvent
+	err := processor.retryWrite(func() error {
+		events = nil
+		rows, err := processor.conn.Query(SelectOpenVoltageEventsQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			event := &VoltageEvent{recorded: true}
+			if err := rows.Scan(&event.Start, &event.Phase, &event.Kind, &event.Extreme); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if previous, ok := processor.voltageEvents[event.Phase]; ok {
+			processor.updateVoltageEvent(previous, event.Start)
+		}
+		processor.voltageEvents[event.Phase] = event
+	}
+
+	return nil
+}
+
+// processVoltage saves the RMS (URMS1-3) and average (UMOY1-3) voltages to
+// the database and feeds the RMS voltages to the sag / swell detector.
+func (processor *Processor) processVoltage(msg TicMessage) error {
+	kind := msg.Field[:len(msg.Field)-1]
+	phase := int(msg.Field[len(msg.Field)-1] - '0')
+	value, err := msg.Integer()
+	if err != nil {
+		return err
+	}
+
+	err = processor.store(msg, Point{
+		Table:     "voltage",
+		Timestamp: time.Time(msg.Timestamp),
+		Tags:      map[string]string{"phase": msg.Field[len(msg.Field)-1:], "kind": kind},
+		Fields:    map[string]interface{}{"voltage": value},
+	})
+	if err != nil {
+		return err
+	}
+
+	if kind != "URMS" {
+		return nil
+	}
+
+	return processor.detectVoltageEvent(time.Time(msg.Timestamp), phase, value)
+}
+
+// detectVoltageEvent records a sag / swell once the voltage of a phase has
+// been out of bounds for longer than the configured duration and closes it
+// when the voltage comes back within bounds.
+func (processor *Processor) detectVoltageEvent(ts time.Time, phase int, value int64) error {
+	config := processor.Config.Voltage
+	if config.Min == 0 && config.Max == 0 {
+		return nil
+	}
+
+	kind := ""
+	if value < config.Min {
+		kind = VOLTAGE_SAG
+	} else if value > config.Max {
+		kind = VOLTAGE_SWELL
+	}
+
+	event, ok := processor.voltageEvents[phase]
+	if ok && event.Kind != kind {
+		delete(processor.voltageEvents, phase)
+		if event.recorded {
+			processor.updateVoltageEvent(event, ts)
+		}
+		ok = false
+	}
+
+	if kind == "" {
+		return nil
+	}
+
+	if !ok {
+		event = &VoltageEvent{
+			Start:   ts,
+			Phase:   phase,
+			Kind:    kind,
+			Extreme: value,
+		}
+		processor.voltageEvents[phase] = event
+	}
+
+	changed := false
+	if (kind == VOLTAGE_SAG && value < event.Extreme) || (kind == VOLTAGE_SWELL && value > event.Extreme) {
+		event.Extreme = value
+		changed = true
+	}
+
+	if !event.recorded && ts.Sub(event.Start) >= config.Duration {
+		processor.exec(InsertVoltageEventQuery,
+			event.Start,
+			event.Phase,
+			event.Kind,
+			event.Extreme)
+		event.recorded = true
+	} else if event.recorded && changed {
+		processor.updateVoltageEvent(event, nil)
+	}
+
+	return nil
+}
+
+// updateVoltageEvent saves the extreme value and end time of a sag / swell
+func (processor *Processor) updateVoltageEvent(event *VoltageEvent, end interface{}) {
+	processor.exec(UpdateVoltageEventQuery,
+		event.Start,
+		event.Phase,
+		end,
+		event.Extreme)
+}